@@ -0,0 +1,236 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// admin serves the admin API of distributors. Every request selects the distributor
+// by its services namespace in the "namespace" query parameter, which may be omitted if there is only one.
+//
+//	GET    /admin/table                       matching table with pinned work units
+//	GET    /admin/owner?unit=                 service owning the work unit, its epoch and lease
+//	GET    /admin/overrides                   work units pinned to services
+//	PUT    /admin/overrides?unit=&service=    pin the work unit to the alive service
+//	DELETE /admin/overrides?unit=             unpin the work unit
+//	GET    /admin/states                      service states set by operators
+//	PUT    /admin/states?service=&state=      set the service state: active, cordoned or draining
+//
+// Modifying requests must have "Authorization: Bearer <token>" header, they are rejected if token is empty.
+type admin struct {
+	distributors map[string]*Distributor // services namespace -> distributor
+	token        string
+}
+
+// ownerEntry is the work unit owner in admin API responses.
+type ownerEntry struct {
+	storage.UnitOwner
+	Lease *storage.Lease `json:"lease,omitempty"`
+}
+
+// tableEntry is the matching table entry of a service in admin API responses.
+type tableEntry struct {
+	State  string   `json:"state"`
+	Units  []string `json:"units"`
+	Pinned []string `json:"pinned,omitempty"`
+}
+
+func newAdmin(distributors []*Distributor, token string) *admin {
+	a := &admin{distributors: make(map[string]*Distributor, len(distributors)), token: token}
+	for _, d := range distributors {
+		a.distributors[d.serviceNamespace] = d
+	}
+
+	return a
+}
+
+func (a *admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d, err := a.distributor(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if r.Method != http.MethodGet && a.token == "" {
+		writeError(w, http.StatusForbidden, fmt.Errorf("admin API is read-only without admin token"))
+		return
+	}
+	if r.Method != http.MethodGet && !a.authorized(r) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid admin token"))
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/admin/table" && r.Method == http.MethodGet:
+		a.table(w, d)
+	case r.URL.Path == "/admin/owner" && r.Method == http.MethodGet:
+		a.owner(w, r, d)
+	case r.URL.Path == "/admin/overrides":
+		a.overrides(w, r, d)
+	case r.URL.Path == "/admin/states":
+		a.states(w, r, d)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown admin method %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (a *admin) distributor(r *http.Request) (*Distributor, error) {
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" && len(a.distributors) == 1 {
+		for _, d := range a.distributors {
+			return d, nil
+		}
+	}
+
+	d, ok := a.distributors[namespace]
+	if !ok {
+		return nil, fmt.Errorf("unknown services namespace %q", namespace)
+	}
+
+	return d, nil
+}
+
+func (a *admin) authorized(r *http.Request) bool {
+	return authorized(r, a.token)
+}
+
+// authorized reports whether the request has the bearer token, no request is authorized if token is empty.
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+func (a *admin) table(w http.ResponseWriter, d *Distributor) {
+	free, pinned := d.splitPinned(d.currentTable())
+
+	res := make(map[string]tableEntry, len(free))
+	for service, units := range free {
+		res[service] = tableEntry{
+			State:  d.serviceState(service),
+			Units:  append(units, pinned[service]...),
+			Pinned: pinned[service],
+		}
+	}
+
+	writeJSON(w, res)
+}
+
+func (a *admin) owner(w http.ResponseWriter, r *http.Request, d *Distributor) {
+	unit := r.URL.Query().Get("unit")
+	if unit == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unit is required"))
+		return
+	}
+
+	owner, err := d.UnitOwner(unit)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("work unit %s has no owner", unit))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := ownerEntry{UnitOwner: owner}
+	if lease, ok := d.Lease(unit); ok {
+		res.Lease = &lease
+	}
+
+	writeJSON(w, res)
+}
+
+func (a *admin) overrides(w http.ResponseWriter, r *http.Request, d *Distributor) {
+	query := r.URL.Query()
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		overrides, err := d.Overrides()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, overrides)
+		return
+	case http.MethodPut, http.MethodPost:
+		if query.Get("unit") == "" || query.Get("service") == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unit and service are required"))
+			return
+		}
+		err = d.SetOverride(query.Get("unit"), query.Get("service"))
+	case http.MethodDelete:
+		if query.Get("unit") == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unit is required"))
+			return
+		}
+		err = d.DelOverride(query.Get("unit"))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	logrus.Infof("%s work unit %s override in the %s namespace", r.Method, query.Get("unit"), d.serviceNamespace)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) states(w http.ResponseWriter, r *http.Request, d *Distributor) {
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		states, err := d.ServiceStates()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, states)
+	case http.MethodPut, http.MethodPost:
+		if query.Get("service") == "" || query.Get("state") == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("service and state are required"))
+			return
+		}
+		if err := d.SetServiceState(query.Get("service"), query.Get("state")); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		logrus.Infof("set service %s state %s in the %s namespace", query.Get("service"), query.Get("state"), d.serviceNamespace)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONCode(w, http.StatusOK, v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSONCode(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSONCode(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Warn(err)
+	}
+}
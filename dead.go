@@ -0,0 +1,194 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/scientificideas/distributor/pinger"
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// deadServices are registered services failing pings: service -> time of the first failed ping.
+// Dead services keep their registrations, get no work and are pinged until they respond again.
+type deadServices struct {
+	mu    sync.RWMutex // mutex for since
+	since map[string]time.Time
+}
+
+func (s *deadServices) get(service string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	since, ok := s.since[service]
+
+	return since, ok
+}
+
+func (s *deadServices) set(service string, since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.since == nil {
+		s.since = make(map[string]time.Time)
+	}
+	s.since[service] = since
+}
+
+func (s *deadServices) del(service string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.since, service)
+}
+
+func (s *deadServices) all() map[string]time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make(map[string]time.Time, len(s.since))
+	for service, since := range s.since {
+		res[service] = since
+	}
+
+	return res
+}
+
+// DeadServices returns registered services failing pings with times of their first failed pings.
+func (d *Distributor) DeadServices() map[string]time.Time {
+	return d.dead.all()
+}
+
+// isDead reports whether the service fails pings.
+func (d *Distributor) isDead(service string) bool {
+	_, ok := d.dead.get(service)
+
+	return ok
+}
+
+// aliveServices returns services which are not dead.
+func (d *Distributor) aliveServices(services []string) []string {
+	var res []string
+	for _, service := range services {
+		if !d.isDead(service) {
+			res = append(res, service)
+		}
+	}
+
+	return res
+}
+
+// evict takes the service failing pings out of the distribution: its work units are deleted from the matching table,
+// but the service stays registered, so it gets work again as soon as it responds.
+// The reverse index and leases are updated in the same transaction, so they don't point to the evicted service.
+func (d *Distributor) evict(service string, since time.Time) error {
+	current := d.currentTable()
+	table := make(map[string][]string, len(current))
+	for s, units := range current {
+		if s != service {
+			table[s] = units
+		}
+	}
+	u, err := d.newTableUpdate(table, false)
+	if err != nil {
+		return err
+	}
+
+	err = storage.Update(d.Storage, func(tx storage.Tx) error {
+		u.apply(tx)
+		if d.deadKey != "" {
+			tx.SetMap(d.deadKey, map[string]interface{}{service: since.UTC().Format(time.RFC3339Nano)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.dead.set(service, since)
+	d.commitTable(u)
+	deadServicesGauge.WithLabelValues(d.serviceNamespace).Set(float64(len(d.dead.all())))
+
+	return nil
+}
+
+// revive returns the dead service which responded again to the distribution.
+func (d *Distributor) revive(service string) error {
+	if d.deadKey != "" {
+		if err := d.Storage.DelFromMap(d.deadKey, service); err != nil {
+			return err
+		}
+	}
+	d.dead.del(service)
+	deadServicesGauge.WithLabelValues(d.serviceNamespace).Set(float64(len(d.dead.all())))
+
+	return nil
+}
+
+// collectDead deletes registrations of services dead longer than the garbage collection period
+// and forgets dead services which are not registered anymore. Services discovered from sources other than
+// the storage list are never deleted, they are forgotten when they disappear from the source.
+func (d *Distributor) collectDead(registered []string, now time.Time) error {
+	registrationsKey := d.registrationsKey()
+	for service, since := range d.dead.all() {
+		collect := registrationsKey != "" && d.deadGCAfter != 0 && now.Sub(since) > d.deadGCAfter
+		if includes(registered, service) && !collect {
+			continue
+		}
+
+		err := storage.Update(d.Storage, func(tx storage.Tx) error {
+			if collect {
+				tx.DelFromList(registrationsKey, service)
+			}
+			if d.deadKey != "" {
+				tx.DelFromMap(d.deadKey, service)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if collect {
+			logrus.Warnf("delete registration of service %s of the %s namespace dead since %s", service, d.serviceNamespace, since.Format(time.RFC3339))
+		}
+		d.dead.del(service)
+	}
+	deadServicesGauge.WithLabelValues(d.serviceNamespace).Set(float64(len(d.dead.all())))
+
+	return nil
+}
+
+// loadDead reads dead services from storage, so the garbage collection period survives restarts.
+func (d *Distributor) loadDead() error {
+	if d.deadKey == "" {
+		return nil
+	}
+
+	stored, err := d.storedMap(d.deadKey)
+	if err != nil {
+		return err
+	}
+	for service, value := range stored {
+		since, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			logrus.Warnf("invalid time of death of service %s: %s", service, err)
+			since = time.Now()
+		}
+		d.dead.set(service, since)
+	}
+
+	return nil
+}
+
+// pingService pings the service with its leases.
+func (d *Distributor) pingService(service string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.transport.PingTimeout)
+	defer cancel()
+
+	return d.ping(pinger.WithLeases(ctx, d.serviceLeases(service)), service)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package discovery
+
+import (
+	"context"
+
+	"github.com/scientificideas/distributor/storage"
+)
+
+// Service is a discovered service.
+type Service struct {
+	Address string // address the service is pinged at, host:port or base URL
+	Ready   bool   // service is ready according to the discovery source, not ready services are not pinged
+}
+
+// Discovery is the source of services of a distribution group.
+type Discovery interface {
+	// Services returns services of the distribution group
+	Services(ctx context.Context) ([]Service, error)
+}
+
+// Watcher is implemented by discovery sources which notify about changes of services.
+type Watcher interface {
+	// Watch notifies about changes of services until ctx is done, notifications are coalesced
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// Addresses returns addresses of services.
+func Addresses(services []Service) []string {
+	res := make([]string, 0, len(services))
+	for _, service := range services {
+		res = append(res, service.Address)
+	}
+
+	return res
+}
+
+// List discovers services registered by themselves in the storage list stored for Key.
+// All registered services are ready, their liveness is checked by pings only.
+type List struct {
+	Storage storage.Storage
+	Key     string
+}
+
+// NewList creates the discovery of services registered in the storage list.
+func NewList(s storage.Storage, key string) *List {
+	return &List{Storage: s, Key: key}
+}
+
+// Services returns services registered in the storage list in the order they were registered.
+func (l *List) Services(_ context.Context) ([]Service, error) {
+	items, err := l.Storage.GetList(l.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Service, 0, len(items))
+	for _, item := range items {
+		res = append(res, Service{Address: item, Ready: true})
+	}
+
+	return res, nil
+}
+
+// Watch notifies about changes of the storage list if the storage is a storage.Watcher.
+func (l *List) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return storage.Watch(ctx, l.Storage, l.Key)
+}
@@ -0,0 +1,318 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Source is the Kubernetes resource services are discovered from.
+type Source string
+
+const (
+	// SourceEndpointSlices discovers endpoints of the Kubernetes Service from its EndpointSlices.
+	SourceEndpointSlices Source = "endpointslices"
+	// SourceEndpoints discovers endpoints of the Kubernetes Service from its Endpoints object for old clusters.
+	SourceEndpoints Source = "endpoints"
+	// SourcePods discovers pods matching the label selector.
+	SourcePods Source = "pods"
+
+	watchRetryInterval = 5 * time.Second // interval between attempts to restart failed watches
+)
+
+// ParseSource returns the Kubernetes discovery source by its name.
+func ParseSource(name string) (Source, error) {
+	switch source := Source(name); source {
+	case SourceEndpointSlices, SourceEndpoints, SourcePods:
+		return source, nil
+	default:
+		return "", fmt.Errorf("unknown Kubernetes discovery source %q", name)
+	}
+}
+
+// KubernetesOptions configure the Kubernetes discovery.
+type KubernetesOptions struct {
+	Source    Source // resource services are discovered from
+	Namespace string // Kubernetes namespace of services
+	Service   string // name of the Kubernetes Service for endpoints sources
+	Selector  string // label selector of pods for the pods source
+	Port      string // name or number of the port services are pinged at, the first port if empty
+}
+
+// Kubernetes discovers services from Kubernetes EndpointSlices, Endpoints or pods, services are addressed
+// as <pod IP>:<port>. Readiness of endpoints and pods is the liveness signal: not ready services get no work.
+type Kubernetes struct {
+	Client kubernetes.Interface
+	opts   KubernetesOptions
+}
+
+// NewKubernetes creates the Kubernetes discovery using the client.
+func NewKubernetes(client kubernetes.Interface, opts KubernetesOptions) (*Kubernetes, error) {
+	switch opts.Source {
+	case SourceEndpointSlices, SourceEndpoints:
+		if opts.Service == "" {
+			return nil, errors.New("Kubernetes Service name is not set")
+		}
+	case SourcePods:
+		if opts.Selector == "" {
+			return nil, errors.New("pods label selector is not set")
+		}
+	default:
+		return nil, fmt.Errorf("unknown Kubernetes discovery source %q", opts.Source)
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = corev1.NamespaceDefault
+	}
+
+	return &Kubernetes{Client: client, opts: opts}, nil
+}
+
+// NewKubernetesClient creates the Kubernetes client configured by the kubeconfig file,
+// the in-cluster configuration of the pod service account is used if kubeconfig is empty.
+func NewKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	var (
+		cfg *rest.Config
+		err error
+	)
+	if kubeconfig == "" {
+		cfg, err = rest.InClusterConfig()
+	} else {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(cfg)
+}
+
+// Services returns services sorted by address, so the distribution doesn't depend on the order of Kubernetes objects.
+func (k *Kubernetes) Services(ctx context.Context) ([]Service, error) {
+	var (
+		services []Service
+		err      error
+	)
+	switch k.opts.Source {
+	case SourceEndpointSlices:
+		services, err = k.endpointSlices(ctx)
+	case SourceEndpoints:
+		services, err = k.endpoints(ctx)
+	case SourcePods:
+		services, err = k.pods(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// an address may be in several EndpointSlices during their update, it's ready if it's ready in any of them
+	ready := make(map[string]bool, len(services))
+	for _, service := range services {
+		ready[service.Address] = ready[service.Address] || service.Ready
+	}
+	res := make([]Service, 0, len(ready))
+	for address, r := range ready {
+		res = append(res, Service{Address: address, Ready: r})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Address < res[j].Address })
+
+	return res, nil
+}
+
+func (k *Kubernetes) endpointSlices(ctx context.Context) ([]Service, error) {
+	slices, err := k.Client.DiscoveryV1().EndpointSlices(k.opts.Namespace).List(ctx, k.listOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Service
+	for _, slice := range slices.Items {
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 && slice.AddressType != discoveryv1.AddressTypeIPv6 {
+			continue
+		}
+		port, ok := slicePort(slice.Ports, k.opts.Port)
+		if !ok {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			// nil readiness means unknown and must be interpreted as ready
+			ready := endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+			for _, address := range endpoint.Addresses {
+				res = append(res, Service{Address: net.JoinHostPort(address, strconv.Itoa(int(port))), Ready: ready})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func slicePort(ports []discoveryv1.EndpointPort, want string) (int32, bool) {
+	for _, port := range ports {
+		if port.Port == nil {
+			continue
+		}
+		if want == "" || (port.Name != nil && *port.Name == want) || strconv.Itoa(int(*port.Port)) == want {
+			return *port.Port, true
+		}
+	}
+
+	return 0, false
+}
+
+func (k *Kubernetes) endpoints(ctx context.Context) ([]Service, error) {
+	endpoints, err := k.Client.CoreV1().Endpoints(k.opts.Namespace).Get(ctx, k.opts.Service, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Service
+	for _, subset := range endpoints.Subsets {
+		port, ok := endpointsPort(subset.Ports, k.opts.Port)
+		if !ok {
+			continue
+		}
+		for _, address := range subset.Addresses {
+			res = append(res, Service{Address: net.JoinHostPort(address.IP, strconv.Itoa(int(port))), Ready: true})
+		}
+		for _, address := range subset.NotReadyAddresses {
+			res = append(res, Service{Address: net.JoinHostPort(address.IP, strconv.Itoa(int(port))), Ready: false})
+		}
+	}
+
+	return res, nil
+}
+
+func endpointsPort(ports []corev1.EndpointPort, want string) (int32, bool) {
+	for _, port := range ports {
+		if want == "" || port.Name == want || strconv.Itoa(int(port.Port)) == want {
+			return port.Port, true
+		}
+	}
+
+	return 0, false
+}
+
+func (k *Kubernetes) pods(ctx context.Context) ([]Service, error) {
+	pods, err := k.Client.CoreV1().Pods(k.opts.Namespace).List(ctx, k.listOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Service
+	for _, pod := range pods.Items {
+		// pods without IPs are not started yet, terminating and finished pods are gone
+		if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		port, ok := podPort(&pod, k.opts.Port)
+		if !ok {
+			logrus.Warnf("pod %s has no port %q, skip it", pod.Name, k.opts.Port)
+			continue
+		}
+		res = append(res, Service{Address: net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port))), Ready: podReady(&pod)})
+	}
+
+	return res, nil
+}
+
+func podPort(pod *corev1.Pod, want string) (int32, bool) {
+	// the port number may be not declared by containers
+	if number, err := strconv.Atoi(want); err == nil {
+		return int32(number), true
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if want == "" || port.Name == want {
+				return port.ContainerPort, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func (k *Kubernetes) listOptions() metav1.ListOptions {
+	if k.opts.Source == SourceEndpointSlices {
+		return metav1.ListOptions{LabelSelector: discoveryv1.LabelServiceName + "=" + k.opts.Service}
+	}
+
+	return metav1.ListOptions{LabelSelector: k.opts.Selector}
+}
+
+// Watch notifies about changes of the discovered Kubernetes objects until ctx is done.
+// Watches closed by the API server are restarted, all changes made meanwhile are found by the next liveness check.
+func (k *Kubernetes) Watch(ctx context.Context) (<-chan struct{}, error) {
+	w, err := k.watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(chan struct{}, 1)
+	go func() {
+		defer close(res)
+		for {
+			for range w.ResultChan() {
+				select {
+				case res <- struct{}{}:
+				default:
+				}
+			}
+			w.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(watchRetryInterval):
+				}
+				if w, err = k.watch(ctx); err == nil {
+					break
+				}
+				logrus.Warnf("failed to watch Kubernetes %s: %s", k.opts.Source, err)
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+func (k *Kubernetes) watch(ctx context.Context) (watch.Interface, error) {
+	switch k.opts.Source {
+	case SourceEndpointSlices:
+		return k.Client.DiscoveryV1().EndpointSlices(k.opts.Namespace).Watch(ctx, k.listOptions())
+	case SourceEndpoints:
+		return k.Client.CoreV1().Endpoints(k.opts.Namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector: "metadata.name=" + k.opts.Service,
+		})
+	default:
+		return k.Client.CoreV1().Pods(k.opts.Namespace).Watch(ctx, k.listOptions())
+	}
+}
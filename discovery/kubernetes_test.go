@@ -0,0 +1,138 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetesEndpointSlices(t *testing.T) {
+	ready, notReady := true, false
+	portName, port := "health", int32(8080)
+	slice := func(name, service string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+		return &discoveryv1.EndpointSlice{
+			ObjectMeta:  metav1.ObjectMeta{Name: name, Namespace: "robots", Labels: map[string]string{discoveryv1.LabelServiceName: service}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   endpoints,
+			Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		slice("robots-1", "robots",
+			discoveryv1.Endpoint{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+			discoveryv1.Endpoint{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+		),
+		// the endpoint moved between slices is ready in one of them
+		slice("robots-2", "robots",
+			discoveryv1.Endpoint{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+			discoveryv1.Endpoint{Addresses: []string{"10.0.0.3"}},
+		),
+		slice("parsers-1", "parsers",
+			discoveryv1.Endpoint{Addresses: []string{"10.0.1.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+		),
+	)
+
+	k, err := NewKubernetes(client, KubernetesOptions{Source: SourceEndpointSlices, Namespace: "robots", Service: "robots", Port: "health"})
+	require.NoError(t, err)
+	services, err := k.Services(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Service{
+		{Address: "10.0.0.1:8080", Ready: true},
+		{Address: "10.0.0.2:8080", Ready: true},
+		{Address: "10.0.0.3:8080", Ready: true},
+	}, services)
+
+	// slices without the port are skipped
+	k, err = NewKubernetes(client, KubernetesOptions{Source: SourceEndpointSlices, Namespace: "robots", Service: "robots", Port: "grpc"})
+	require.NoError(t, err)
+	services, err = k.Services(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, services)
+
+	_, err = NewKubernetes(client, KubernetesOptions{Source: SourceEndpointSlices})
+	assert.Error(t, err)
+}
+
+func TestKubernetesEndpoints(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "robots", Namespace: corev1.NamespaceDefault},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses:         []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+			NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}},
+			Ports:             []corev1.EndpointPort{{Name: "metrics", Port: 9090}, {Name: "health", Port: 8080}},
+		}},
+	})
+
+	k, err := NewKubernetes(client, KubernetesOptions{Source: SourceEndpoints, Service: "robots", Port: "8080"})
+	require.NoError(t, err)
+	services, err := k.Services(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Service{
+		{Address: "10.0.0.1:8080", Ready: true},
+		{Address: "10.0.0.2:8080", Ready: false},
+	}, services)
+}
+
+func TestKubernetesPods(t *testing.T) {
+	pod := func(name, ip string, ready corev1.ConditionStatus, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "robots", Labels: labels},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Ports: []corev1.ContainerPort{{Name: "health", ContainerPort: 8080}},
+			}}},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				PodIP:      ip,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+	robots := map[string]string{"app": "robots"}
+	terminating := pod("robot-4", "10.0.0.4", corev1.ConditionTrue, robots)
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	client := fake.NewSimpleClientset(
+		pod("robot-1", "10.0.0.1", corev1.ConditionTrue, robots),
+		pod("robot-2", "10.0.0.2", corev1.ConditionFalse, robots),
+		pod("robot-3", "", corev1.ConditionFalse, robots),
+		terminating,
+		pod("parser-1", "10.0.1.1", corev1.ConditionTrue, map[string]string{"app": "parsers"}),
+	)
+
+	k, err := NewKubernetes(client, KubernetesOptions{Source: SourcePods, Namespace: "robots", Selector: "app=robots", Port: "health"})
+	require.NoError(t, err)
+	services, err := k.Services(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Service{
+		{Address: "10.0.0.1:8080", Ready: true},
+		{Address: "10.0.0.2:8080", Ready: false},
+	}, services)
+
+	// changes of pods are watched
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := k.Watch(ctx)
+	require.NoError(t, err)
+	_, err = client.CoreV1().Pods("robots").Create(ctx, pod("robot-5", "10.0.0.5", corev1.ConditionTrue, robots), metav1.CreateOptions{})
+	require.NoError(t, err)
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("no notification about the new pod")
+	}
+	services, err = k.Services(ctx)
+	require.NoError(t, err)
+	assert.Len(t, services, 3)
+}
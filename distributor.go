@@ -9,12 +9,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/scientificideas/distributor/discovery"
 	"github.com/scientificideas/distributor/pinger"
 	"github.com/scientificideas/distributor/storage"
-	"github.com/serialx/hashring"
 	"github.com/sirupsen/logrus"
-	"sort"
-	"strings"
 	"sync"
 	"time"
 )
@@ -22,6 +20,10 @@ import (
 const (
 	defaultPingTimeout  = 800 * time.Millisecond // default time to wait for service response
 	defaultPollInterval = 500 * time.Millisecond // default service ping interval
+
+	defaultRebalanceInterval = 30 * time.Second // default interval of load rebalancing
+
+	discoveryTimeout = 10 * time.Second // timeout of service discovery requests
 )
 
 // Distributor manages SeviceCache, checks services liveness, and updates services-to-work-units matching table.
@@ -29,11 +31,43 @@ type Distributor struct {
 	Storage               storage.Storage
 	ringMembers           string
 	serviceNamespace      string
+	servicesKey           string              // key of storage list with services, serviceNamespace if empty
+	discovery             discovery.Discovery // source of services, the storage list stored for servicesKey if nil
 	distributionNamespace string
 	p                     pinger.Pinger
 	serviceCache          ServiceCache
 	workUnitsCache        WorkUnitsCache
+	statusCache           StatusCache
 	transport             *Transport
+	strategy              Strategy
+	rebalanceInterval     time.Duration            // interval of load rebalancing for LoadBalancer strategies
+	loadMetricsKey        string                   // key of storage hash table with work units load, not used if empty
+	overridesKey          string                   // key of storage hash table with work units pinned to services, not used if empty
+	overridesMu           sync.RWMutex             // mutex for overrides
+	overrides             map[string]string        // overrides applied by the last distribution: work unit -> service
+	ownersKey             string                   // key of storage hash table with work units owners, not used if empty
+	leasesKey             string                   // key of storage hash table with work units leases, not used if empty
+	leaseDuration         time.Duration            // duration of work units leases, leases are not issued if zero
+	leasesMu              sync.RWMutex             // mutex for leases
+	leases                map[string]storage.Lease // current leases: work unit -> lease
+	statesKey             string                   // key of storage hash table with service states, not used if empty
+	keepCordonedUnits     bool                     // keep work units on cordoned services
+	statesMu              sync.RWMutex             // mutex for states
+	states                map[string]string        // service states applied by the last distribution: service -> state
+	budget                Budget                   // limits of work units moves between live services
+	plan                  Plan                     // staged migration to the target table within budget
+	tableFormat           storage.TableFormat      // encoding of matching table values
+	tableMu               sync.RWMutex             // mutex for table, epochs and meta
+	table                 map[string][]string      // matching table last written to storage
+	epochs                map[string]int64         // epochs of services work units in the matching table
+	meta                  map[string]tableMeta     // roles and lease expiries of services in the matching table
+	health                *StorageHealth           // storage health, evictions are paused while it's unavailable
+	recoveries            int64                    // storage recoveries the Distributor reconciled after
+	cycles                cycleStats               // timings of liveness checks
+	maxEvictFraction      float64                  // max fraction of services evicted at once, not limited if zero
+	dead                  deadServices             // registered services failing pings
+	deadKey               string                   // key of storage hash table with dead services, not used if empty
+	deadGCAfter           time.Duration            // registrations of services dead longer are deleted, never if zero
 }
 
 // Transport configures network parameters of Distributor.
@@ -69,6 +103,24 @@ func NewDistributor(distributionNamespace, ringMembers, serviceNamespace string,
 	if d.transport.PollInterval == 0 {
 		d.transport.PollInterval = defaultPollInterval
 	}
+	if d.strategy == nil {
+		d.strategy = DeterministicStrategy{}
+	}
+	if d.rebalanceInterval == 0 {
+		d.rebalanceInterval = defaultRebalanceInterval
+	}
+	if d.budget.Interval == 0 {
+		d.budget.Interval = defaultPlanInterval
+	}
+	if d.tableFormat == "" {
+		d.tableFormat = storage.FormatLegacy
+	}
+	if _, ok := d.Storage.(storage.Counter); d.leasesEnabled() && !ok {
+		return nil, errors.New("leases need a storage with counters for fencing tokens")
+	}
+	if _, ok := d.Storage.(storage.MapGetter); !ok && (d.loadMetricsKey != "" || d.overridesKey != "" || d.statesKey != "") {
+		return nil, errors.New("load metrics, overrides and service states need a storage reading whole maps")
+	}
 	if distributionNamespace == "" {
 		return nil, errors.New("got empty work distribution namespace")
 	}
@@ -81,8 +133,22 @@ func NewDistributor(distributionNamespace, ringMembers, serviceNamespace string,
 		return nil, errors.New("service namespace is not set (-services-namespaces= or SERVICES_NAMESPACES)")
 	}
 	d.serviceNamespace = serviceNamespace
+	if d.servicesKey == "" {
+		d.servicesKey = serviceNamespace
+	}
+	if d.discovery == nil {
+		d.discovery = discovery.NewList(d.Storage, d.servicesKey)
+	}
+	d.cycles.created = time.Now()
 	d.serviceCache.services = make(map[string]struct{})
 	d.workUnitsCache.workunits = make(map[string]struct{})
+	d.statusCache.statuses = make(map[string]*pinger.Status)
+	if err := d.loadTable(); err != nil {
+		return nil, err
+	}
+	if err := d.loadDead(); err != nil {
+		return nil, err
+	}
 	urls, err := d.Services()
 	if err != nil {
 		return nil, err
@@ -94,38 +160,314 @@ func NewDistributor(distributionNamespace, ringMembers, serviceNamespace string,
 }
 
 // PutToMatchingTable creates hash table in storage where each service has its own range of ring hash members.
+// The distribution is made by the Distributor strategy, deterministic by default.
+// Work units pinned by operators to alive services are assigned to them regardless of the strategy.
+// Cordoned and draining services get no new work units, cordoned ones keep their work units if configured.
+// If the Distributor has a move budget, the table is migrated to the new distribution in several steps.
 func (d *Distributor) PutToMatchingTable(services, ringMembers []string) error {
-	ring := hashring.NewWithWeights(nil)
-	for _, member := range ringMembers {
-		ring = ring.AddWeightedNode(member, 50)
+	loads, err := d.unitLoads()
+	if err != nil {
+		return err
+	}
+
+	active, kept, err := d.inactiveUnits(services)
+	if err != nil {
+		return err
+	}
+	if len(active) == 0 {
+		return fmt.Errorf("no one service of the %s namespace is active", d.serviceNamespace)
 	}
 
-	// find matching ring members for every item
-	var matchingTable = make(map[string]interface{})
-	//var listOfItems string
-	itemsCountPerMember := len(ringMembers) / len(services)
-	if itemsCountPerMember < 1 {
-		itemsCountPerMember = 1
+	// pinned work units are assigned before automatic distribution, draining services don't keep them
+	var pinnable []string
+	for _, service := range services {
+		if d.serviceState(service) != StateDraining {
+			pinnable = append(pinnable, service)
+		}
+	}
+	pinned, err := d.pinnedUnits(pinnable, ringMembers)
+	if err != nil {
+		return err
+	}
+	assigned := make(map[string]struct{})
+	for _, units := range pinned {
+		for _, unit := range units {
+			assigned[unit] = struct{}{}
+		}
 	}
 
-	sort.Strings(services) // sort services list for consistent results
-	for i, service := range services {
-		if i == len(services)-1 && len(ringMembers) > len(services) { // if it's the last member and we have more free buckets than itemsCountPerMember,
-			itemsCountPerMember = itemsCountPerMember + len(ringMembers)%len(services) // give to this member itemsCountPerMember + len(ringMembers) % len(services) buckets
+	// cordoned services keep only existing work units which are not pinned
+	for service, units := range kept {
+		var keep []string
+		for _, unit := range units {
+			if _, ok := assigned[unit]; !ok && includes(ringMembers, unit) {
+				keep = append(keep, unit)
+				assigned[unit] = struct{}{}
+			}
 		}
-		matchingMembers, ok := ring.GetNodes(service, itemsCountPerMember)
+		kept[service] = append([]string{}, keep...)
+	}
+
+	var free []string
+	for _, unit := range ringMembers {
+		if _, ok := assigned[unit]; !ok {
+			free = append(free, unit)
+		}
+	}
+
+	current, _ := d.splitPinned(d.currentTable())
+	table := withPinned(withPinned(d.strategy.Table(current, active, free, loads), kept), pinned)
+	if d.budget.unlimited() {
+		return d.writeTable(table)
+	}
+
+	return d.setTarget(table)
+}
+
+// writeTable saves to storage the difference between the current and the given matching tables:
+// changed services work units and services absent in the given table. The epoch of every changed service is incremented.
+// The reverse index of work units owners and leases are updated in the same transaction.
+func (d *Distributor) writeTable(table map[string][]string) error {
+	u, err := d.newTableUpdate(table, false)
+	if err != nil {
+		return err
+	}
+
+	if u.changed {
+		logrus.Debugf("new matching table: %v", u.set[d.distributionNamespace])
+		if err = storage.UpdateMaps(d.Storage, u.set, u.del); err != nil {
+			return err
+		}
+	}
+	d.commitTable(u)
+
+	return nil
+}
+
+// tableUpdate is the change of the matching table with the reverse index and leases changed with it.
+type tableUpdate struct {
+	set     map[string]map[string]interface{} // changed fields of maps
+	del     map[string][]string               // deleted fields of maps
+	changed bool                              // whether the matching table is changed
+
+	table  map[string][]string
+	epochs map[string]int64
+	meta   map[string]tableMeta
+	leases map[string]storage.Lease // nil if leases are disabled
+}
+
+// tableMeta is the role and the lease expiry of the service written to its matching table value in the JSON format.
+type tableMeta struct {
+	role        string
+	leaseExpiry time.Time
+}
+
+// serviceMeta returns the state of the service unless it's active and the earliest expiry of the leases it holds.
+func (d *Distributor) serviceMeta(service string, units []string, leases map[string]storage.Lease) tableMeta {
+	var meta tableMeta
+	if state := d.serviceState(service); state != StateActive {
+		meta.role = state
+	}
+	for _, unit := range units {
+		if lease, ok := leases[unit]; ok && lease.Service == service && (meta.leaseExpiry.IsZero() || lease.Expiry.Before(meta.leaseExpiry)) {
+			meta.leaseExpiry = lease.Expiry.Round(0).UTC() // comparable with expiries read from storage
+		}
+	}
+
+	return meta
+}
+
+// newTableUpdate returns the change of storage making the given matching table the current one.
+// If renew, leases of all work units are extended.
+func (d *Distributor) newTableUpdate(table map[string][]string, renew bool) (*tableUpdate, error) {
+	current := d.currentTable()
+
+	d.tableMu.RLock()
+	currentEpochs := d.epochs
+	currentMeta := d.meta
+	epochs := make(map[string]int64, len(table))
+	for service := range table {
+		epochs[service] = d.epochs[service]
+	}
+	d.tableMu.RUnlock()
+
+	var leases map[string]storage.Lease
+	if d.leasesEnabled() {
+		var err error
+		if leases, err = d.nextLeases(table, renew); err != nil {
+			return nil, err
+		}
+	}
+
+	// the legacy format has no meta, so values are rewritten only when work units change
+	var matchingTable = make(map[string]interface{}, len(table))
+	meta := make(map[string]tableMeta, len(table))
+	for service, units := range table {
+		meta[service] = d.serviceMeta(service, units, leases)
+		currentUnits, ok := current[service]
+		unitsChanged := !ok || !equal(currentUnits, units)
+		if unitsChanged {
+			epochs[service]++
+		}
+		if unitsChanged || (d.tableFormat == storage.FormatJSON && meta[service] != currentMeta[service]) {
+			value, err := storage.EncodeTableValue(tableValue(units, epochs[service], meta[service]), d.tableFormat)
+			if err != nil {
+				return nil, err
+			}
+			matchingTable[service] = value
+		}
+	}
+
+	var deleted []string
+	for service := range current {
+		if _, ok := table[service]; !ok {
+			deleted = append(deleted, service)
+		}
+	}
+
+	u := &tableUpdate{
+		set:     map[string]map[string]interface{}{d.distributionNamespace: matchingTable},
+		del:     map[string][]string{d.distributionNamespace: deleted},
+		changed: len(matchingTable) != 0 || len(deleted) != 0,
+		table:   table,
+		epochs:  epochs,
+		meta:    meta,
+		leases:  leases,
+	}
+	if d.ownersKey != "" {
+		changed, removed, err := ownersDiff(unitOwners(current, currentEpochs), unitOwners(table, epochs))
+		if err != nil {
+			return nil, err
+		}
+		u.set[d.ownersKey] = changed
+		u.del[d.ownersKey] = removed
+	}
+	if d.leasesEnabled() {
+		d.leasesMu.RLock()
+		changed, removed, err := leasesDiff(d.leases, u.leases)
+		d.leasesMu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		u.set[d.leasesKey] = changed
+		u.del[d.leasesKey] = removed
+	}
+
+	return u, nil
+}
+
+// tableValue returns the matching table value of the service.
+func tableValue(units []string, epoch int64, meta tableMeta) storage.TableValue {
+	value := storage.TableValue{Units: units, Epoch: epoch, Role: meta.role}
+	if !meta.leaseExpiry.IsZero() {
+		value.LeaseExpiry = &meta.leaseExpiry
+	}
+
+	return value
+}
+
+// apply adds the change to the storage transaction.
+func (u *tableUpdate) apply(tx storage.Tx) {
+	for key, fields := range u.set {
+		tx.SetMap(key, fields)
+	}
+	for key, fields := range u.del {
+		tx.DelFromMap(key, fields...)
+	}
+}
+
+// commitTable makes the table of the update written to storage the current one.
+func (d *Distributor) commitTable(u *tableUpdate) {
+	d.tableMu.Lock()
+	d.table = u.table
+	d.epochs = u.epochs
+	d.meta = u.meta
+	d.tableMu.Unlock()
+	if u.leases != nil {
+		d.setLeases(u.leases)
+	}
+}
+
+// loadTable reads the matching table from storage and makes it the current one, so the Distributor continues
+// with the distribution made before restart. Services and work units of the loaded table are added to the caches,
+// therefore the table is changed only if services or work units changed during the restart.
+func (d *Distributor) loadTable() error {
+	services, err := d.Services()
+	if err != nil {
+		return err
+	}
+	stored, err := d.storedMap(d.distributionNamespace)
+	if err != nil {
+		return err
+	}
+
+	// the matching table is shared with other services namespaces, take only own services
+	table := make(map[string][]string)
+	epochs := make(map[string]int64)
+	meta := make(map[string]tableMeta)
+	for _, service := range services {
+		data, ok := stored[service]
 		if !ok {
-			logrus.Debugf("failed to find matching hash ring member for item %s, skipping", service)
+			continue
+		}
+		value, err := storage.DecodeTableValue(data)
+		if err != nil {
+			return fmt.Errorf("invalid matching table value of service %s: %w", service, err)
+		}
+		table[service] = value.Units
+		epochs[service] = value.Epoch
+		m := tableMeta{role: value.Role}
+		if value.LeaseExpiry != nil {
+			m.leaseExpiry = value.LeaseExpiry.Round(0).UTC()
 		}
-		// rm member from hash ring to avoid duplication: one ring member can't be associated with two services
-		for _, member := range matchingMembers {
-			ring = ring.RemoveNode(member)
+		meta[service] = m
+		d.serviceCache.add(service)
+		// work units deleted during the restart are removed from the table by the next liveness check
+		for _, unit := range table[service] {
+			d.workUnitsCache.add(unit)
 		}
-		matchingTable[service] = strings.Join(matchingMembers, ",")
 	}
 
-	logrus.Debugf("new matching table: %v", matchingTable)
-	return d.Storage.SetMap(d.distributionNamespace, matchingTable)
+	d.tableMu.Lock()
+	d.table = table
+	d.epochs = epochs
+	d.meta = meta
+	d.tableMu.Unlock()
+
+	logrus.Debugf("loaded matching table of the %s namespace: %v", d.serviceNamespace, table)
+
+	if err = d.loadLeases(); err != nil {
+		return err
+	}
+
+	return d.syncOwners()
+}
+
+// storedMap reads the map written by the Distributor before restart. Storages which can't read whole maps
+// are considered empty, so the Distributor starts over as if nothing was written.
+func (d *Distributor) storedMap(key string) (map[string]string, error) {
+	m, err := storage.GetMap(d.Storage, key)
+	if errors.Is(err, storage.ErrNotSupported) {
+		return map[string]string{}, nil
+	}
+
+	return m, err
+}
+
+// currentTable returns matching table last written to storage.
+func (d *Distributor) currentTable() map[string][]string {
+	d.tableMu.RLock()
+	defer d.tableMu.RUnlock()
+
+	return d.table
+}
+
+// assignedUnits returns work units assigned to the service in the matching table.
+func (d *Distributor) assignedUnits(service string) []string {
+	d.tableMu.RLock()
+	defer d.tableMu.RUnlock()
+
+	return d.table[service]
 }
 
 func (d *Distributor) balance() error {
@@ -143,17 +485,60 @@ func (d *Distributor) balance() error {
 	if len(services) == 0 {
 		return fmt.Errorf("no one service responded")
 	}
+	services = d.eligibleServices(d.aliveServices(services))
+	if len(services) == 0 {
+		return fmt.Errorf("no one service is ready to accept work")
+	}
 	return d.PutToMatchingTable(services, ringMembers)
 }
 
-// Services returns all services registered in storage.
+// Services returns all discovered services, ready or not.
 func (d *Distributor) Services() ([]string, error) {
-	return d.Storage.GetList(d.serviceNamespace)
+	services, err := d.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	return discovery.Addresses(services), nil
+}
+
+// discover returns services of the discovery source.
+func (d *Distributor) discover() ([]discovery.Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	return d.discovery.Services(ctx)
+}
+
+// registrationsKey returns the key of the storage list services register themselves in,
+// empty if services are discovered from another source.
+func (d *Distributor) registrationsKey() string {
+	if list, ok := d.discovery.(*discovery.List); ok && list.Storage == d.Storage {
+		return list.Key
+	}
+
+	return ""
 }
 
-// RingMembers returns all ring members (work units) registered in storage.
+// RingMembers returns all valid ring members (work units) registered in storage.
+// Work units with IDs which can't be stored in the matching table are skipped.
 func (d *Distributor) RingMembers() ([]string, error) {
-	return d.Storage.GetList(d.ringMembers)
+	members, err := d.Storage.GetList(d.ringMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(members))
+	for _, member := range members {
+		if err := storage.ValidateUnitID(member, d.tableFormat); err != nil {
+			logrus.Warnf("skip work unit %q of the %s namespace: %s", member, d.serviceNamespace, err)
+			continue
+		}
+		res = append(res, member)
+	}
+	invalidUnits.WithLabelValues(d.serviceNamespace).Set(float64(len(members) - len(res)))
+
+	return res, nil
 }
 
 func (s *ServiceCache) add(service string) {
@@ -216,6 +601,19 @@ func (s *WorkUnitsCache) all() []string {
 	return workUnits
 }
 
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 func includes(source []string, item string) bool {
 	for _, s := range source {
 		if s == item {
@@ -228,37 +626,77 @@ func includes(source []string, item string) bool {
 // LivenessCheck checks current active services by ping them, checks storage for new services and rebalance work units
 // if new units of work (ring members) appear in the storage, they will be distributed among services in the balance() method call.
 func (d *Distributor) LivenessCheck() error {
-	servicesFromStorage, err := d.Services()
+	d.cycles.start()
+	err := d.livenessCheck()
+	d.cycles.finish(err)
+
+	return err
+}
+
+func (d *Distributor) livenessCheck() error {
+	if err := d.checkStorage(); err != nil {
+		return err
+	}
+
+	discovered, err := d.discover()
 	if err != nil {
+		if d.registrationsKey() != "" {
+			d.storageFailed(err)
+		}
 		return err
 	}
+	servicesFromStorage := discovery.Addresses(discovered)
+	notReady := make(map[string]bool)
+	for _, service := range discovered {
+		if !service.Ready {
+			notReady[service.Address] = true
+		}
+	}
 	workunitsFromStorage, err := d.RingMembers()
 	if err != nil {
+		d.storageFailed(err)
 		return err
 	}
 
 	// check that all services from cache still exist in storage
 	allCachedServices := d.serviceCache.all()
 	for _, serviceFromCache := range allCachedServices {
-		// service deleted from storage, let's rebalance matching table in storage and del redundant service from cache
+		// service deleted from storage, let's del redundant service from cache and rebalance matching table in storage
 		if !includes(servicesFromStorage, serviceFromCache) {
 			logrus.Debugf("%s service deleted from the storage, rebalance and del redundant item from the cache", serviceFromCache)
 			// del from local cache
 			logrus.Debugf("delete service %s from the cache of the %s namespace", serviceFromCache, d.serviceNamespace)
 			d.serviceCache.del(serviceFromCache)
-			// del from storage hash table
-			logrus.Debugf("delete service %s from the storage matching table", serviceFromCache)
-			if err = d.Storage.DelFromMap(d.distributionNamespace, serviceFromCache); err != nil {
-				return err
-			}
-			// rebalance
+			// rebalance, the new matching table drops the service with its owners and leases in one update
 			if err := d.balance(); err != nil {
 				return err
 			}
 		}
 	}
 
+	var failed, unready []string
 	for _, service := range servicesFromStorage {
+		// dead services get work again as soon as they respond
+		if d.isDead(service) {
+			if notReady[service] {
+				continue
+			}
+			if _, err := d.pingService(service); err != nil {
+				continue
+			}
+			logrus.Infof("service %s of the %s namespace responds again, rebalance", service, d.serviceNamespace)
+			if err := d.revive(service); err != nil {
+				d.storageFailed(err)
+				return err
+			}
+			if err := d.balance(); err != nil {
+				logrus.Warn(err)
+			} else {
+				d.serviceCache.add(service)
+			}
+			continue
+		}
+
 		// rebalance if this service doesn't exist in distributor cache
 		if !d.serviceCache.exist(service) {
 			logrus.Debugf("no service %s in the cache of the %s namespace, rebalance", service, d.serviceNamespace)
@@ -270,46 +708,73 @@ func (d *Distributor) LivenessCheck() error {
 			}
 		}
 
+		// not ready services are not pinged and evicted like services which don't respond
+		if notReady[service] {
+			logrus.Warnf("service %s is not ready according to the discovery", service)
+			unready = append(unready, service)
+			continue
+		}
+
 		// rebalance if service doesn't respond correctly (timing,service error network errors, service fault)
-		ctx, _ := context.WithTimeout(context.Background(), d.transport.PingTimeout)
-		if err := d.p.Ping(ctx, service); err != nil {
+		eligibilityChanged, err := d.pingService(service)
+		if err != nil {
 			logrus.Warnf("ping %s service error: %s", service, err)
-			// rm faulty service from cache
-			logrus.Warnf("delete %s service from the local cache of the %s namespace", service, d.serviceNamespace)
-			d.serviceCache.del(service)
-
-			// del from storage services list
-			logrus.Warnf("delete %s service from the storage services list", service)
-			if err = d.Storage.DelFromList(d.serviceNamespace, service); err != nil {
-				return err
-			}
-			// del from storage hash table
-			logrus.Warnf("delete %s service from storage matching table", service)
-			if err = d.Storage.DelFromMap(d.distributionNamespace, service); err != nil {
-				return err
-			}
-			// rebalance
+			failed = append(failed, service)
+		} else if eligibilityChanged {
+			// rebalance if service became ready or not ready for work, or started draining
+			logrus.Infof("service %s of the %s namespace changed its readiness for work, rebalance", service, d.serviceNamespace)
 			if err := d.balance(); err != nil {
 				return err
 			}
 		}
 	}
 
+	// evict failed services unless too many of them failed pings at once. Not ready services are evicted anyway,
+	// since the discovery reports them regardless of the Distributor network, e.g. during rolling updates.
+	guarded := d.evictionGuarded(len(failed), len(d.aliveServices(servicesFromStorage))-len(unready))
+	evicted := unready
+	if !guarded {
+		evicted = append(evicted, failed...)
+	}
+	now := time.Now()
+	for _, service := range evicted {
+		// rm faulty service from cache
+		logrus.Warnf("delete %s service from the local cache of the %s namespace", service, d.serviceNamespace)
+		d.serviceCache.del(service)
+		d.statusCache.del(service)
+		foreignUnits.DeleteLabelValues(d.serviceNamespace, service)
+
+		// del from storage hash table unless the storage failed during the check
+		if err = d.checkStorage(); err != nil {
+			return err
+		}
+		logrus.Warnf("delete %s service from the storage matching table, keep its registration", service)
+		if err = d.evict(service, now); err != nil {
+			d.storageFailed(err)
+			return err
+		}
+		// rebalance
+		if err := d.balance(); err != nil {
+			return err
+		}
+	}
+	if !guarded {
+		if err = d.collectDead(servicesFromStorage, now); err != nil {
+			d.storageFailed(err)
+			return err
+		}
+	}
+
 	// check work units
 	allCachedWorkUnits := d.workUnitsCache.all()
 	for _, workUnitFromCache := range allCachedWorkUnits {
-		// workunit deleted from storage, let's rebalance matching table in storage and del redundant workunit from cache
+		// workunit deleted from storage, let's del redundant workunit from cache and rebalance matching table in storage
 		if !includes(workunitsFromStorage, workUnitFromCache) {
 			logrus.Debugf("%s work unit deleted from the storage, rebalance and del redundant item from the cache of the %s namespace", workUnitFromCache, d.serviceNamespace)
 			// del from local cache
 			logrus.Debugf("delete work unit %s from the cache of the %s namespace", workUnitFromCache, d.serviceNamespace)
 			d.workUnitsCache.del(workUnitFromCache)
-			// del from storage hash table
-			logrus.Debugf("delete work unit %s from the storage matching table", workUnitFromCache)
-			if err = d.Storage.DelFromMap(d.distributionNamespace, workUnitFromCache); err != nil {
-				return err
-			}
-			// rebalance
+			// rebalance, the new matching table drops the work unit with its owner in one update
 			if err := d.balance(); err != nil {
 				return err
 			}
@@ -329,16 +794,138 @@ func (d *Distributor) LivenessCheck() error {
 		}
 	}
 
+	// rebalance if operators pinned or unpinned work units
+	overridesChanged, err := d.overridesChanged()
+	if err != nil {
+		return err
+	}
+	if overridesChanged {
+		logrus.Infof("work units overrides of the %s namespace changed, rebalance", d.serviceNamespace)
+		if err := d.balance(); err != nil {
+			return err
+		}
+	}
+
+	// rebalance if operators cordoned, drained or activated services
+	statesChanged, err := d.statesChanged()
+	if err != nil {
+		return err
+	}
+	if statesChanged {
+		logrus.Infof("service states of the %s namespace changed, rebalance", d.serviceNamespace)
+		if err := d.balance(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Run performs a liveness check and work units distribution at the interval specified in 'pollInterval' arg.
+// Run performs liveness checks and work units distribution until the process exits, see RunContext.
 func (d *Distributor) Run(errorsChan chan error) {
+	d.RunContext(context.Background(), errorsChan)
+}
+
+// RunContext performs a liveness check and work units distribution at the interval specified in 'pollInterval' arg.
+// If the Distributor strategy balances work units by load, RunContext also rebalances them at the rebalance interval.
+// If the Distributor has a move budget, RunContext moves work units towards the target table at the budget interval.
+// If the Distributor issues leases, RunContext renews them three times per lease duration.
+// RunContext returns when ctx is done.
+func (d *Distributor) RunContext(ctx context.Context, errorsChan chan error) {
 	t := time.NewTicker(d.transport.PollInterval)
+	defer t.Stop()
+	var rebalance, planStep, renew <-chan time.Time
+	if _, ok := d.strategy.(LoadBalancer); ok {
+		rt := time.NewTicker(d.rebalanceInterval)
+		defer rt.Stop()
+		rebalance = rt.C
+	}
+	if !d.budget.unlimited() {
+		pt := time.NewTicker(d.budget.Interval)
+		defer pt.Stop()
+		planStep = pt.C
+	}
+	if d.leasesEnabled() {
+		lt := time.NewTicker(d.leaseDuration / 3)
+		defer lt.Stop()
+		renew = lt.C
+	}
+	changes := d.watchLists(ctx)
 	for {
-		<-t.C
-		if err := d.LivenessCheck(); err != nil {
-			errorsChan <- err
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := d.LivenessCheck(); err != nil {
+				errorsChan <- err
+			}
+		case <-changes:
+			// services or work units changed, don't wait for the next poll
+			if err := d.LivenessCheck(); err != nil {
+				errorsChan <- err
+			}
+		case <-rebalance:
+			if err := d.rebalanceByLoad(); err != nil {
+				errorsChan <- err
+			}
+		case <-planStep:
+			if err := d.stepPlan(); err != nil {
+				errorsChan <- err
+			}
+		case <-renew:
+			if err := d.renewLeases(); err != nil {
+				errorsChan <- err
+			}
 		}
 	}
 }
+
+// watchLists returns the channel notified about changes of the services and work units lists until ctx is done,
+// nil if the storage can't watch them, so the Distributor only polls.
+func (d *Distributor) watchLists(ctx context.Context) <-chan struct{} {
+	watcher, ok := d.discovery.(discovery.Watcher)
+	if !ok {
+		logrus.Debugf("services of the %s namespace aren't watched", d.serviceNamespace)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	services, err := watcher.Watch(ctx)
+	if err != nil {
+		cancel()
+		logrus.Debugf("services of the %s namespace aren't watched: %s", d.serviceNamespace, err)
+		return nil
+	}
+	units, err := storage.Watch(ctx, d.Storage, d.ringMembers)
+	if err != nil {
+		cancel() // stops the services watch
+		logrus.Debugf("work units list %s isn't watched: %s", d.ringMembers, err)
+		return nil
+	}
+
+	res := make(chan struct{}, 1)
+	go func() {
+		defer cancel()
+		for services != nil || units != nil {
+			var ok bool
+			select {
+			case _, ok = <-services:
+				if !ok {
+					services = nil
+				}
+			case _, ok = <-units:
+				if !ok {
+					units = nil
+				}
+			}
+			if !ok {
+				continue
+			}
+			select {
+			case res <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return res
+}
@@ -6,9 +6,23 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/scientificideas/distributor/config"
+	"github.com/scientificideas/distributor/discovery"
 	"github.com/scientificideas/distributor/mocks"
+	"github.com/scientificideas/distributor/pinger"
+	"github.com/scientificideas/distributor/storage"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -105,15 +119,20 @@ func TestLivenessCheck(t *testing.T) {
 }
 
 func CreateDistributor(testData TestData) (*Distributor, error) {
-	mockStorage := &mocks.MockStorage{
-		Lists:     make(map[string][]string),
-		HashTable: make(map[string]string),
+	stor := storage.NewMemory()
+	for _, service := range testData.Services {
+		if err := stor.AddToList(testData.ServicesListsKeys, service); err != nil {
+			return nil, err
+		}
+	}
+	for _, unit := range testData.WorkUnits {
+		if err := stor.AddToList(testData.RingMembersKey, unit); err != nil {
+			return nil, err
+		}
 	}
-	mockStorage.Lists[testData.ServicesListsKeys] = testData.Services
-	mockStorage.Lists[testData.RingMembersKey] = testData.WorkUnits
 
 	return NewDistributor(testData.DistributionNamespace, testData.RingMembersKey, testData.ServicesListsKeys,
-		mocks.NewMockPinger(), WithStorage(mockStorage), WithTransport(&Transport{
+		mocks.NewMockPinger(), WithStorage(stor), WithTransport(&Transport{
 			PingTimeout:  time.Millisecond,
 			PollInterval: time.Millisecond,
 		}))
@@ -130,3 +149,1031 @@ func GetServicesAndRingMembers(distributor *Distributor) ([]string, []string, er
 	}
 	return servicesFromStorage, workunitsFromStorage, nil
 }
+
+func TestLoadAwareRebalance(t *testing.T) {
+	strategy := LoadAwareStrategy{Hysteresis: 0.2, MaxMoves: 1}
+	table := map[string][]string{
+		"service1": {"work1", "work2", "work3", "work4"},
+		"service2": {},
+	}
+	loads := map[string]float64{"work1": 1, "work2": 1, "work3": 1, "work4": 1}
+
+	// only one work unit is moved per rebalance
+	table, moves := strategy.Rebalance(table, loads)
+	assert.Equal(t, 1, moves)
+	assert.Len(t, table["service1"], 3)
+	assert.Len(t, table["service2"], 1)
+
+	table, moves = strategy.Rebalance(table, loads)
+	assert.Equal(t, 1, moves)
+	assert.Len(t, table["service1"], 2)
+	assert.Len(t, table["service2"], 2)
+
+	// balanced services are left as is
+	_, moves = strategy.Rebalance(table, loads)
+	assert.Equal(t, 0, moves)
+
+	// new work units go to the least loaded service, current assignments are kept
+	table = strategy.Table(table, []string{"service1", "service2"}, []string{"work1", "work2", "work3", "work4", "work5"},
+		map[string]float64{"work1": 5, "work2": 1, "work3": 1, "work4": 1})
+	assert.Equal(t, []string{"work1", "work2"}, table["service2"])
+	assert.Equal(t, []string{"work3", "work4", "work5"}, table["service1"])
+}
+
+func TestBudget(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithBudget(Budget{MaxMoves: 1})(distributor))
+
+	units := []string{"work1", "work2", "work3", "work4", "work5", "work6"}
+	// the first distribution has no live owners, so it's applied at once
+	assert.NoError(t, distributor.PutToMatchingTable([]string{"service1", "service2"}, units))
+	assert.Equal(t, 0, distributor.PlanStatus().Pending)
+
+	target := deterministicTable([]string{"service1", "service2", "service3"}, units)
+	total := countMoves(distributor.currentTable(), target)
+	assert.NotZero(t, total)
+
+	// rebalancing records the target, every step moves one work unit
+	assert.NoError(t, distributor.PutToMatchingTable([]string{"service1", "service2", "service3"}, units))
+	for pending := total; pending > 0; pending-- {
+		assert.Equal(t, pending, distributor.PlanStatus().Pending)
+		assert.NoError(t, distributor.stepPlan())
+	}
+	assert.Equal(t, 0, distributor.PlanStatus().Pending)
+	assert.Equal(t, total, distributor.PlanStatus().Done)
+	assert.Equal(t, len(target), len(distributor.currentTable()))
+	for service, units := range target {
+		assert.ElementsMatch(t, units, distributor.currentTable()[service])
+	}
+}
+
+func TestBudgetSeveralRebalances(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithBudget(Budget{MaxMoves: 1})(distributor))
+	stor := distributor.Storage.(*storage.Memory)
+	assert.NoError(t, distributor.balance())
+	before := distributor.currentTable()
+
+	// new services and work units within one interval don't move work units between live services
+	for _, service := range []string{"service4", "service5", "service6"} {
+		assert.NoError(t, stor.AddToList(distributor.servicesKey, service))
+		assert.NoError(t, distributor.balance())
+	}
+	assert.NoError(t, stor.AddToList(distributor.ringMembers, "work4"))
+	assert.NoError(t, distributor.balance())
+	assert.Zero(t, countMoves(before, distributor.currentTable()))
+	assert.Contains(t, owners(distributor.currentTable()), "work4")
+	assert.NotZero(t, distributor.PlanStatus().Pending)
+
+	// the interval step moves at most MaxMoves work units
+	current := distributor.currentTable()
+	assert.NoError(t, distributor.stepPlan())
+	assert.LessOrEqual(t, countMoves(current, distributor.currentTable()), 1)
+	assert.LessOrEqual(t, distributor.PlanStatus().Done, 1)
+
+	// work units of failed services are reassigned at once
+	failed := owners(distributor.currentTable())["work1"]
+	assert.NoError(t, stor.DelFromList(distributor.servicesKey, failed))
+	assert.NoError(t, distributor.balance())
+	assert.NotEqual(t, failed, owners(distributor.currentTable())["work1"])
+	assert.NotContains(t, distributor.currentTable(), failed)
+}
+
+// countingStorage counts matching table updates.
+type countingStorage struct {
+	storage.Extended
+	updates int
+}
+
+func (s *countingStorage) SetMap(key string, m map[string]interface{}) error {
+	s.updates++
+
+	return s.Extended.SetMap(key, m)
+}
+
+func (s *countingStorage) UpdateMaps(set map[string]map[string]interface{}, del map[string][]string) error {
+	s.updates++
+
+	return s.Extended.UpdateMaps(set, del)
+}
+
+func (s *countingStorage) DelFromMap(mapname string, field string) error {
+	s.updates++
+
+	return s.Extended.DelFromMap(mapname, field)
+}
+
+func TestRestart(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+	table := distributor.currentTable()
+
+	// the restarted Distributor continues with the stored table and doesn't change it
+	stor := &countingStorage{Extended: distributor.Storage.(storage.Extended)}
+	restarted, err := NewDistributor(distributor.distributionNamespace, distributor.ringMembers, distributor.serviceNamespace,
+		mocks.NewMockPinger(), WithStorage(stor), WithTransport(distributor.transport))
+	assert.NoError(t, err)
+	assert.Equal(t, table, restarted.currentTable())
+	assert.NoError(t, restarted.LivenessCheck())
+	assert.Equal(t, 0, stor.updates)
+	assert.Equal(t, table, restarted.currentTable())
+}
+
+func TestMinimalStorage(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	testData := TestTable["TestPutToMatchingTable"]
+	stor := storage.NewMemory()
+	for _, service := range testData.Services {
+		assert.NoError(t, stor.AddToList(testData.ServicesListsKeys, service))
+	}
+	for _, unit := range testData.WorkUnits {
+		assert.NoError(t, stor.AddToList(testData.RingMembersKey, unit))
+	}
+
+	// the storage with only the methods of the Storage interface gets the matching table and the reverse index
+	minimal := struct{ storage.Storage }{stor}
+	distributor, err := NewDistributor(testData.DistributionNamespace, testData.RingMembersKey, testData.ServicesListsKeys,
+		mocks.NewMockPinger(), WithStorage(minimal), WithTransport(&Transport{}), WithOwnersIndex("testOwnersKey"))
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+	table, err := stor.GetMap(testData.DistributionNamespace)
+	assert.NoError(t, err)
+	assert.Len(t, table, len(distributor.currentTable()))
+	owner, err := distributor.UnitOwner("work1")
+	assert.NoError(t, err)
+	assert.Equal(t, owners(distributor.currentTable())["work1"], owner.Service)
+
+	// features reading whole maps are refused
+	_, err = NewDistributor(testData.DistributionNamespace, testData.RingMembersKey, testData.ServicesListsKeys,
+		mocks.NewMockPinger(), WithStorage(minimal), WithTransport(&Transport{}), WithOverrides("testOverridesKey"))
+	assert.Error(t, err)
+}
+
+func TestOverrides(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithOverrides("testOverridesKey")(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+
+	// the pinned work unit moves to its service on the next liveness check
+	assert.NoError(t, distributor.SetOverride("work1", "service3"))
+	assert.Error(t, distributor.SetOverride("work1", "unknownService"))
+	assert.Error(t, distributor.SetOverride("unknownWork", "service3"))
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Equal(t, "service3", owners(distributor.currentTable())["work1"])
+
+	// the unpinned work unit is distributed automatically again
+	assert.NoError(t, distributor.DelOverride("work1"))
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Equal(t, "service2", owners(distributor.currentTable())["work1"])
+}
+
+func TestAdminAuth(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithOverrides("testOverridesKey")(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+	request := func(a *admin, method, target, token string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// without token the admin API is read-only
+	a := newAdmin([]*Distributor{distributor}, "")
+	assert.Equal(t, http.StatusOK, request(a, http.MethodGet, "/admin/overrides", ""))
+	assert.Equal(t, http.StatusForbidden, request(a, http.MethodPut, "/admin/overrides?unit=work1&service=service3", ""))
+	assert.Equal(t, http.StatusForbidden, request(a, http.MethodPut, "/admin/states?service=service3&state=cordoned", ""))
+
+	a = newAdmin([]*Distributor{distributor}, "secret")
+	assert.Equal(t, http.StatusUnauthorized, request(a, http.MethodDelete, "/admin/overrides?unit=work1", "wrong"))
+	assert.Equal(t, http.StatusNoContent, request(a, http.MethodPut, "/admin/overrides?unit=work1&service=service3", "secret"))
+	assert.Equal(t, http.StatusBadRequest, request(a, http.MethodPut, "/admin/overrides?unit=work9&service=service3", "secret"))
+}
+
+func TestServiceStates(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	for _, keep := range []bool{false, true} {
+		distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+		assert.NoError(t, err)
+		assert.NoError(t, WithServiceStates("testStatesKey", keep)(distributor))
+		assert.NoError(t, distributor.LivenessCheck())
+		units := distributor.currentTable()["service3"]
+		assert.NotEmpty(t, units)
+
+		// the cordoned service is still pinged and stays in the table, but gets no new work units
+		assert.NoError(t, distributor.SetServiceState("service3", StateCordoned))
+		assert.Error(t, distributor.SetServiceState("service3", "unknown"))
+		assert.NoError(t, distributor.LivenessCheck())
+		assert.True(t, distributor.serviceCache.exist("service3"))
+		if keep {
+			assert.Equal(t, units, distributor.currentTable()["service3"])
+		} else {
+			assert.Empty(t, distributor.currentTable()["service3"])
+		}
+		stor := distributor.Storage.(*storage.Memory)
+		assert.NoError(t, stor.AddToList(distributor.ringMembers, "work4"))
+		assert.NoError(t, distributor.LivenessCheck())
+		assert.NotEqual(t, "service3", owners(distributor.currentTable())["work4"])
+
+		// the draining service gives away all its work units
+		assert.NoError(t, distributor.SetServiceState("service3", StateDraining))
+		assert.NoError(t, distributor.LivenessCheck())
+		assert.Empty(t, distributor.currentTable()["service3"])
+
+		// the activated service gets work again
+		assert.NoError(t, distributor.SetServiceState("service3", StateActive))
+		assert.NoError(t, distributor.LivenessCheck())
+		assert.NotEmpty(t, distributor.currentTable()["service3"])
+	}
+}
+
+func TestUnitOwners(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestLivenessCheck"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithOwnersIndex("testOwnersKey")(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+
+	// the reverse index matches the matching table
+	for service, units := range distributor.currentTable() {
+		for _, unit := range units {
+			owner, err := distributor.UnitOwner(unit)
+			assert.NoError(t, err)
+			assert.Equal(t, service, owner.Service)
+			assert.Equal(t, distributor.epochs[service], owner.Epoch)
+		}
+	}
+	_, err = distributor.UnitOwner("unknownWork")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// the index is rebuilt on start if it's missing
+	stor := distributor.Storage.(*storage.Memory)
+	index, err := stor.GetMap("testOwnersKey")
+	assert.NoError(t, err)
+	for unit := range index {
+		assert.NoError(t, stor.DelFromMap("testOwnersKey", unit))
+	}
+	restarted, err := NewDistributor(distributor.distributionNamespace, distributor.ringMembers, distributor.serviceNamespace,
+		mocks.NewMockPinger(), WithStorage(stor), WithTransport(distributor.transport), WithOwnersIndex("testOwnersKey"))
+	assert.NoError(t, err)
+	owner, err := restarted.UnitOwner("work1")
+	assert.NoError(t, err)
+	assert.Equal(t, owners(distributor.currentTable())["work1"], owner.Service)
+}
+
+func TestLeases(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithLeases("testLeasesKey", time.Minute)(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+
+	leases := make(map[string]storage.Lease)
+	for service, units := range distributor.currentTable() {
+		for _, unit := range units {
+			lease, ok := distributor.Lease(unit)
+			assert.True(t, ok)
+			assert.Equal(t, service, lease.Service)
+			assert.NotZero(t, lease.Token)
+			leases[unit] = lease
+		}
+		assert.Len(t, distributor.serviceLeases(service), len(units))
+	}
+
+	// renewed leases keep their tokens
+	assert.NoError(t, distributor.renewLeases())
+	for unit, lease := range leases {
+		renewed, _ := distributor.Lease(unit)
+		assert.Equal(t, lease.Token, renewed.Token)
+		assert.False(t, renewed.Expiry.Before(lease.Expiry))
+	}
+
+	// reassigned work units keep leases of the previous owner until they expire
+	stor := distributor.Storage.(*storage.Memory)
+	assert.NoError(t, stor.DelFromList(distributor.serviceNamespace, "service3"))
+	assert.NoError(t, distributor.LivenessCheck())
+	for unit, lease := range leases {
+		if lease.Service == "service3" {
+			current, _ := distributor.Lease(unit)
+			assert.Equal(t, lease.Token, current.Token)
+		}
+	}
+	for service := range distributor.currentTable() {
+		for _, lease := range distributor.serviceLeases(service) {
+			assert.NotEqual(t, "service3", leases[lease.Unit].Service)
+		}
+	}
+
+	// after the expiry reassigned work units get greater tokens, kept ones keep their leases
+	expired := make(map[string]storage.Lease)
+	for unit := range leases {
+		lease, _ := distributor.Lease(unit)
+		if lease.Service == "service3" {
+			lease.Expiry = time.Now().Add(-time.Second)
+		}
+		expired[unit] = lease
+	}
+	distributor.setLeases(expired)
+	assert.NoError(t, distributor.renewLeases())
+	var maxToken int64
+	for _, lease := range leases {
+		if lease.Token > maxToken {
+			maxToken = lease.Token
+		}
+	}
+	for unit, lease := range leases {
+		next, ok := distributor.Lease(unit)
+		assert.True(t, ok)
+		if lease.Service == "service3" {
+			assert.Greater(t, next.Token, maxToken)
+		} else if next.Service == lease.Service {
+			assert.Equal(t, lease.Token, next.Token)
+		}
+	}
+
+	// leases need a valid duration and a storage with counters
+	assert.Error(t, WithLeases("testLeasesKey", time.Nanosecond)(distributor))
+	_, err = NewDistributor("table", "units", "services", mocks.NewMockPinger(),
+		WithStorage(struct{ storage.Storage }{storage.NewMemory()}), WithTransport(&Transport{}), WithLeases("testLeasesKey", time.Minute))
+	assert.Error(t, err)
+}
+
+func TestMigrateKeys(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	configuration := &config.Config{
+		DistributionNamespace: "table",
+		WorkunitsNamespace:    "units",
+		LeasesNamespace:       "leases",
+		OverridesNamespace:    "overrides",
+		KeyLayout:             layoutHashTag,
+	}
+	_, err := newGroupKeys(configuration, "flat", "robots")
+	assert.Error(t, err)
+	keys, err := newGroupKeys(configuration, layoutHashTag, "robots")
+	assert.NoError(t, err)
+	assert.Equal(t, groupKeys{
+		Services:  "{robots}:services",
+		WorkUnits: "{robots}:units",
+		Table:     "{robots}:table",
+		Leases:    "{robots}:leases",
+		Overrides: "{robots}:overrides",
+	}, keys)
+
+	// the legacy matching table is shared by the robots and parsers groups
+	stor := storage.NewMemory()
+	for _, service := range []string{"robot1", "robot2"} {
+		assert.NoError(t, stor.AddToList("robots", service))
+	}
+	assert.NoError(t, stor.AddToList("parsers", "parser1"))
+	assert.NoError(t, stor.AddToList("units", "work1"))
+	assert.NoError(t, stor.SetMap("table", map[string]interface{}{"robot1": "work1", "robot2": "", "parser1": "work1"}))
+	assert.NoError(t, stor.SetMap("overrides:robots", map[string]interface{}{"work1": "robot1"}))
+	_, err = stor.IncrBy("leases:robots:token", 5)
+	assert.NoError(t, err)
+
+	// migrated keys aren't overwritten unless the migration is forced
+	assert.NoError(t, migrateGroupKeys(stor, configuration, "robots"))
+	assert.NoError(t, stor.SetMap(keys.Overrides, map[string]interface{}{"work1": "robot2"}))
+	assert.Error(t, migrateGroupKeys(stor, configuration, "robots"))
+	overrides, err := stor.GetMap(keys.Overrides)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"work1": "robot2"}, overrides)
+	configuration.MigrateForce = true
+	assert.NoError(t, migrateGroupKeys(stor, configuration, "robots"))
+	services, err := stor.GetList(keys.Services)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"robot1", "robot2"}, services)
+	units, err := stor.GetList(keys.WorkUnits)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"work1"}, units)
+	table, err := stor.GetMap(keys.Table)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"robot1": "work1", "robot2": ""}, table)
+	overrides, err = stor.GetMap(keys.Overrides)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"work1": "robot1"}, overrides)
+	token, err := stor.IncrBy(keys.Leases+":token", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), token)
+
+	// the Distributor works with the migrated keys
+	distributor, err := NewDistributor(keys.Table, keys.WorkUnits, "robots", mocks.NewMockPinger(),
+		WithStorage(stor), WithTransport(&Transport{}), WithServicesKey(keys.Services), WithOverrides(keys.Overrides))
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Equal(t, []string{"work1"}, distributor.currentTable()["robot1"])
+
+	configuration.KeyLayout = layoutLegacy
+	assert.Error(t, migrateGroupKeys(stor, configuration, "robots"))
+}
+
+// flakyStorage fails all requests while it's down.
+type flakyStorage struct {
+	*storage.Memory
+	down bool
+}
+
+func (s *flakyStorage) GetList(key string) ([]string, error) {
+	if s.down {
+		return nil, errors.New("connection refused")
+	}
+
+	return s.Memory.GetList(key)
+}
+
+func (s *flakyStorage) Ping(ctx context.Context) error {
+	if s.down {
+		return errors.New("connection refused")
+	}
+
+	return nil
+}
+
+func TestStorageHealth(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	stor := &flakyStorage{Memory: distributor.Storage.(*storage.Memory)}
+	health := NewStorageHealth(stor, time.Hour, time.Second)
+	distributor, err = NewDistributor(distributor.distributionNamespace, distributor.ringMembers, distributor.serviceNamespace,
+		mocks.NewMockPinger(), WithStorage(stor), WithTransport(distributor.transport), WithStorageHealth(health))
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+
+	// a failed request marks the storage unavailable, services are not evicted until it recovers
+	stor.down = true
+	assert.Error(t, distributor.LivenessCheck())
+	assert.False(t, health.Healthy())
+	assert.NoError(t, stor.AddToList(distributor.servicesKey, "badService1"))
+	assert.NoError(t, stor.AddToList(distributor.ringMembers, "work4"))
+	stor.down = false
+	assert.ErrorIs(t, distributor.LivenessCheck(), errStorageUnavailable)
+	services, err := distributor.Services()
+	assert.NoError(t, err)
+	assert.Contains(t, services, "badService1")
+
+	// the recovered storage is reconciled
+	health.check()
+	assert.True(t, health.Healthy())
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Contains(t, owners(distributor.currentTable()), "work4")
+	services, err = distributor.Services()
+	assert.NoError(t, err)
+	assert.Contains(t, services, "badService1")
+	assert.Contains(t, distributor.DeadServices(), "badService1")
+}
+
+func TestProbes(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestLivenessCheck"])
+	assert.NoError(t, err)
+	stor := &flakyStorage{Memory: distributor.Storage.(*storage.Memory)}
+	health := NewStorageHealth(stor, time.Hour, time.Second)
+	p := newProbes(health, []*Distributor{distributor}, time.Minute)
+	probe := func(handler http.HandlerFunc) (int, probeResult) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		var res probeResult
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		return rec.Code, res
+	}
+
+	// not ready until the first successful liveness check
+	code, _ := probe(p.readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.NoError(t, distributor.LivenessCheck())
+	code, res := probe(p.readyz)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", res.Status)
+
+	// the storage outage makes the Distributor unready, but it's still alive
+	stor.down = true
+	health.check()
+	code, _ = probe(p.readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	code, _ = probe(p.healthz)
+	assert.Equal(t, http.StatusOK, code)
+	stor.down = false
+	health.check()
+
+	// the hung liveness check stalls the loop
+	distributor.cycles.start()
+	distributor.cycles.started = time.Now().Add(-2 * time.Minute)
+	code, res = probe(p.healthz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", res.Status)
+	distributor.cycles.finish(nil)
+
+	rec := httptest.NewRecorder()
+	p.status(rec, httptest.NewRequest(http.MethodGet, "/debug/status", nil))
+	var status struct {
+		Storage StorageStatus `json:"storage"`
+		Groups  []GroupStatus `json:"groups"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.True(t, status.Storage.Healthy)
+	assert.Equal(t, int64(1), status.Storage.Recoveries)
+	assert.Len(t, status.Groups, 1)
+	assert.Equal(t, 3, status.Groups[0].AliveServices)
+	assert.Equal(t, 3, status.Groups[0].WorkUnits)
+	assert.False(t, status.Groups[0].Stalled)
+}
+
+func TestEvictionGuard(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.Error(t, WithEvictionGuard(1.5)(distributor))
+	assert.NoError(t, WithEvictionGuard(0.5)(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+	table := distributor.currentTable()
+
+	// the Distributor is partitioned from all services: nobody is evicted and the table is kept
+	p := distributor.p.(*mocks.MockPinger)
+	p.FailAll = true
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, distributor.LivenessCheck())
+	}
+	services, err := distributor.Services()
+	assert.NoError(t, err)
+	assert.Equal(t, TestTable["TestPutToMatchingTable"].Services, services)
+	assert.Equal(t, table, distributor.currentTable())
+
+	assert.Empty(t, distributor.DeadServices())
+
+	// a single failed service is evicted
+	p.FailAll = false
+	assert.NoError(t, distributor.Storage.(*storage.Memory).AddToList(distributor.servicesKey, "badService1"))
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Contains(t, distributor.DeadServices(), "badService1")
+
+	// without the guard all services are evicted
+	assert.NoError(t, WithEvictionGuard(0)(distributor))
+	p.FailAll = true
+	assert.Error(t, distributor.LivenessCheck())
+	assert.Len(t, distributor.DeadServices(), 4)
+	assert.Empty(t, distributor.currentTable())
+}
+
+func TestDeadServices(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithDeadServices("sys-service-health:robots", time.Minute)(distributor))
+	assert.NoError(t, WithEvictionGuard(0)(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+
+	// failed services keep their registrations, but get no work
+	p := distributor.p.(*mocks.MockPinger)
+	p.FailAll = true
+	assert.Error(t, distributor.LivenessCheck())
+	services, err := distributor.Services()
+	assert.NoError(t, err)
+	assert.Equal(t, TestTable["TestPutToMatchingTable"].Services, services)
+	assert.Len(t, distributor.DeadServices(), 3)
+	assert.Empty(t, distributor.currentTable())
+
+	// dead services survive restarts
+	restarted, err := NewDistributor(distributor.distributionNamespace, distributor.ringMembers, distributor.serviceNamespace,
+		p, WithStorage(distributor.Storage), WithTransport(distributor.transport), WithDeadServices(distributor.deadKey, time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, restarted.DeadServices(), 3)
+	for service, since := range distributor.DeadServices() {
+		assert.True(t, since.Equal(restarted.DeadServices()[service]))
+	}
+
+	// recovered services get work again
+	p.FailAll = false
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Empty(t, distributor.DeadServices())
+	assert.Len(t, owners(distributor.currentTable()), 3)
+	dead, err := storage.GetMap(distributor.Storage, distributor.deadKey)
+	assert.NoError(t, err)
+	assert.Empty(t, dead)
+
+	// registrations of services dead longer than the garbage collection period are deleted
+	assert.NoError(t, distributor.Storage.(*storage.Memory).AddToList(distributor.servicesKey, "badService1"))
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Contains(t, distributor.DeadServices(), "badService1")
+	distributor.dead.set("badService1", time.Now().Add(-2*time.Minute))
+	assert.NoError(t, distributor.LivenessCheck())
+	services, err = distributor.Services()
+	assert.NoError(t, err)
+	assert.NotContains(t, services, "badService1")
+	assert.Empty(t, distributor.DeadServices())
+}
+
+func TestEvictionIndexes(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithDeadServices("sys-service-health:robots", time.Minute)(distributor))
+	assert.NoError(t, WithOwnersIndex("testOwnersKey")(distributor))
+	assert.NoError(t, WithLeases("testLeasesKey", time.Minute)(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+	evicted := owners(distributor.currentTable())["work1"]
+	lease, ok := distributor.Lease("work1")
+	assert.True(t, ok)
+
+	// the work units of the evicted service have no owner at once
+	assert.NoError(t, distributor.evict(evicted, time.Now()))
+	_, err = distributor.UnitOwner("work1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	index, err := storage.GetMap(distributor.Storage, "testOwnersKey")
+	assert.NoError(t, err)
+	for unit, value := range index {
+		owner, err := storage.DecodeUnitOwner(value)
+		assert.NoError(t, err)
+		assert.NotEqual(t, evicted, owner.Service, unit)
+	}
+
+	// the stored leases match the current ones: the evicted service keeps its leases until they expire
+	stored, err := storage.GetMap(distributor.Storage, "testLeasesKey")
+	assert.NoError(t, err)
+	assert.Len(t, stored, len(distributor.leases))
+	for unit, value := range stored {
+		storedLease, err := storage.DecodeLease(value)
+		assert.NoError(t, err)
+		current, _ := distributor.Lease(unit)
+		assert.True(t, storedLease.Expiry.Equal(current.Expiry), unit)
+		assert.Equal(t, current.Token, storedLease.Token, unit)
+	}
+	current, ok := distributor.Lease("work1")
+	assert.True(t, ok)
+	assert.Equal(t, lease.Token, current.Token)
+	assert.Equal(t, evicted, current.Service)
+}
+
+func TestDeregistrationIndexes(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	testData := TestTable["TestPutToMatchingTable"]
+	distributor, err := CreateDistributor(testData)
+	assert.NoError(t, err)
+	assert.NoError(t, WithOwnersIndex("testOwnersKey")(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+	removed := owners(distributor.currentTable())["work1"]
+
+	// the deregistered service is dropped from the stored table together with its owners
+	assert.NoError(t, distributor.Storage.DelFromList(testData.ServicesListsKeys, removed))
+	assert.NoError(t, distributor.LivenessCheck())
+	table, err := storage.GetMap(distributor.Storage, testData.DistributionNamespace)
+	assert.NoError(t, err)
+	assert.NotContains(t, table, removed)
+	assert.Len(t, table, len(distributor.currentTable()))
+	index, err := storage.GetMap(distributor.Storage, "testOwnersKey")
+	assert.NoError(t, err)
+	assert.Len(t, index, len(testData.WorkUnits))
+	for unit, value := range index {
+		owner, err := storage.DecodeUnitOwner(value)
+		assert.NoError(t, err)
+		assert.NotEqual(t, removed, owner.Service, unit)
+	}
+}
+
+// failingBolt is the bolt storage rejecting writes to the key inside its transactions.
+type failingBolt struct {
+	*storage.Bolt
+	key string
+}
+
+func (s *failingBolt) Tx(fn func(tx storage.Tx) error) error {
+	return s.Bolt.Tx(func(tx storage.Tx) error {
+		return fn(&failingTx{Tx: tx, key: s.key})
+	})
+}
+
+type failingTx struct {
+	storage.Tx
+	key string
+}
+
+func (tx *failingTx) SetMap(key string, m map[string]interface{}) {
+	if key == tx.key {
+		m = map[string]interface{}{"": "rejected"} // bbolt refuses empty field names when the transaction is applied
+	}
+	tx.Tx.SetMap(key, m)
+}
+
+func TestEvictionFailure(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	testData := TestTable["TestPutToMatchingTable"]
+	b, err := storage.NewBolt(filepath.Join(t.TempDir(), "distributor.db"))
+	assert.NoError(t, err)
+	defer b.Close()
+	for _, service := range testData.Services {
+		assert.NoError(t, b.AddToList(testData.ServicesListsKeys, service))
+	}
+	for _, unit := range testData.WorkUnits {
+		assert.NoError(t, b.AddToList(testData.RingMembersKey, unit))
+	}
+	stor := &failingBolt{Bolt: b, key: "sys-service-health:robots"}
+	distributor, err := NewDistributor(testData.DistributionNamespace, testData.RingMembersKey, testData.ServicesListsKeys,
+		mocks.NewMockPinger(), WithStorage(stor), WithTransport(&Transport{}), WithDeadServices("sys-service-health:robots", time.Minute),
+		WithOwnersIndex("testOwnersKey"), WithLeases("testLeasesKey", time.Minute))
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+	table := distributor.currentTable()
+	stored := make(map[string]map[string]string)
+	for _, key := range []string{testData.DistributionNamespace, "testOwnersKey", "testLeasesKey"} {
+		stored[key], err = b.GetMap(key)
+		assert.NoError(t, err)
+	}
+
+	// the write of the dead services map fails after the matching table changes, so nothing is changed
+	evicted := owners(table)["work1"]
+	assert.Error(t, distributor.evict(evicted, time.Now()))
+	assert.False(t, distributor.isDead(evicted))
+	assert.Equal(t, table, distributor.currentTable())
+	for key, m := range stored {
+		current, err := b.GetMap(key)
+		assert.NoError(t, err)
+		assert.Equal(t, m, current, key)
+	}
+	owner, err := distributor.UnitOwner("work1")
+	assert.NoError(t, err)
+	assert.Equal(t, evicted, owner.Service)
+	lease, ok := distributor.Lease("work1")
+	assert.True(t, ok)
+	assert.Equal(t, evicted, lease.Service)
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	stor := storage.NewMemory()
+	for _, unit := range []string{"work1", "work2", "work3"} {
+		assert.NoError(t, stor.AddToList("units", unit))
+	}
+	pod := func(name, ip string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "robots", Labels: map[string]string{"app": "robots"}},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				PodIP:      ip,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(pod("robot-1", "10.0.0.1", corev1.ConditionTrue), pod("robot-2", "10.0.0.2", corev1.ConditionFalse))
+	k, err := discovery.NewKubernetes(client, discovery.KubernetesOptions{
+		Source: discovery.SourcePods, Namespace: "robots", Selector: "app=robots", Port: "8080",
+	})
+	assert.NoError(t, err)
+	distributor, err := NewDistributor("table", "units", "robots", mocks.NewMockPinger(),
+		WithStorage(stor), WithTransport(&Transport{}), WithDiscovery(k))
+	assert.NoError(t, err)
+
+	// the not ready pod gets no work
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Len(t, distributor.currentTable()["10.0.0.1:8080"], 3)
+	assert.Contains(t, distributor.DeadServices(), "10.0.0.2:8080")
+
+	// the pod became ready
+	ctx := context.Background()
+	_, err = client.CoreV1().Pods("robots").UpdateStatus(ctx, pod("robot-2", "10.0.0.2", corev1.ConditionTrue), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Empty(t, distributor.DeadServices())
+	assert.Len(t, distributor.currentTable(), 2)
+
+	// the deleted pod is removed from the table
+	assert.NoError(t, client.CoreV1().Pods("robots").Delete(ctx, "robot-1", metav1.DeleteOptions{}))
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Len(t, distributor.currentTable()["10.0.0.2:8080"], 3)
+	assert.NotContains(t, distributor.currentTable(), "10.0.0.1:8080")
+}
+
+func TestServiceStatus(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	p := mocks.NewMockStatusPinger()
+	distributor.p = p
+	assert.NoError(t, distributor.LivenessCheck())
+
+	// not ready services are not eligible
+	p.Statuses["service1"] = &pinger.Status{}
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.NotContains(t, distributor.currentTable(), "service1")
+
+	// draining services are not eligible
+	p.Statuses["service1"] = &pinger.Status{Ready: true, Draining: true}
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.NotContains(t, distributor.currentTable(), "service1")
+
+	// the service became ready
+	p.Statuses["service1"] = &pinger.Status{Ready: true}
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Contains(t, distributor.currentTable(), "service1")
+
+	// only changes of eligibility are reported, services which don't report status are eligible
+	delete(p.Statuses, "service1")
+	changed, err := distributor.ping(context.Background(), "service1")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	p.Statuses["service1"] = &pinger.Status{}
+	changed, err = distributor.ping(context.Background(), "service1")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = distributor.ping(context.Background(), "service1")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the service answering under another identity fails ping
+	p.Statuses["service2"] = &pinger.Status{ServiceID: "service3", Ready: true}
+	_, err = distributor.ping(context.Background(), "service2")
+	assert.Error(t, err)
+
+	// work units processed by the service without being assigned to it are reported
+	p.Statuses["service3"] = &pinger.Status{Ready: true, Units: append([]string{"work9"}, distributor.assignedUnits("service3")...)}
+	_, err = distributor.ping(context.Background(), "service3")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(foreignUnits.WithLabelValues(distributor.serviceNamespace, "service3")))
+	p.Statuses["service3"] = &pinger.Status{Ready: true, Units: distributor.assignedUnits("service3")}
+	_, err = distributor.ping(context.Background(), "service3")
+	assert.NoError(t, err)
+	assert.Zero(t, testutil.ToFloat64(foreignUnits.WithLabelValues(distributor.serviceNamespace, "service3")))
+}
+
+func TestStorageAPI(t *testing.T) {
+	stor := storage.NewMemory()
+	keys := []groupKeys{{Services: "robots", WorkUnits: "units", Table: "table", Leases: "sys-leases:robots"}}
+	_, err := newStorageAPI(stor, "", keys)
+	assert.Error(t, err)
+	api, err := newStorageAPI(stor, "secret", keys)
+	assert.NoError(t, err)
+	assert.NoError(t, stor.SetMap("table", map[string]interface{}{"robot1": "work1"}))
+	assert.NoError(t, stor.SetMap("sys-leases:robots", map[string]interface{}{"work1": "{}"}))
+	request := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// all requests require the token
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/storage/list?key=robots", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPut, "/storage/list?key=robots&item=robot1", "wrong").Code)
+
+	// services register and read the matching table
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPut, "/storage/list?key=robots&item=robot1", "secret").Code)
+	rec := request(http.MethodGet, "/storage/list?key=robots", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `["robot1"]`, rec.Body.String())
+	rec = request(http.MethodGet, "/storage/map?key=table&field=robot1", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `"work1"`, rec.Body.String())
+
+	// other keys are not served
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/storage/map?key=sys-leases:robots", "secret").Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPut, "/storage/list?key=other&item=robot1", "secret").Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/storage/list?key=table", "secret").Code)
+}
+
+func TestTableFormat(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	// existing consumers split values by commas unless JSON is enabled
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+	table, err := storage.GetMap(distributor.Storage, distributor.distributionNamespace)
+	assert.NoError(t, err)
+	assert.Equal(t, "work1", table["service2"])
+
+	assert.NoError(t, WithTableFormat(storage.FormatJSON)(distributor))
+	assert.NoError(t, distributor.Storage.(*storage.Memory).AddToList(distributor.ringMembers, "work4"))
+	assert.NoError(t, distributor.LivenessCheck())
+	table, err = storage.GetMap(distributor.Storage, distributor.distributionNamespace)
+	assert.NoError(t, err)
+	owner := owners(distributor.currentTable())["work4"]
+	assert.True(t, strings.HasPrefix(table[owner], `{"v":1,`), table[owner])
+}
+
+func TestTableValueMeta(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithTableFormat(storage.FormatJSON)(distributor))
+	assert.NoError(t, WithServiceStates("testStatesKey", true)(distributor))
+	assert.NoError(t, WithLeases("testLeasesKey", time.Minute)(distributor))
+	assert.NoError(t, distributor.LivenessCheck())
+	stored := func(service string) storage.TableValue {
+		data, err := storage.GetField(distributor.Storage, distributor.distributionNamespace, service)
+		assert.NoError(t, err)
+		value, err := storage.DecodeTableValue(data)
+		assert.NoError(t, err)
+		return value
+	}
+
+	// the value has the earliest expiry of the service leases and no role while the service is active
+	value := stored("service3")
+	assert.Empty(t, value.Role)
+	assert.NotNil(t, value.LeaseExpiry)
+	for _, lease := range distributor.serviceLeases("service3") {
+		assert.False(t, lease.Expiry.Before(*value.LeaseExpiry))
+	}
+
+	// the cordoned service keeping its work units gets the role without a new epoch
+	assert.NoError(t, distributor.SetServiceState("service3", StateCordoned))
+	assert.NoError(t, distributor.LivenessCheck())
+	cordoned := stored("service3")
+	assert.Equal(t, StateCordoned, cordoned.Role)
+	assert.Equal(t, value.Epoch, cordoned.Epoch)
+
+	// renewed leases move the expiry forward without a new epoch
+	assert.NoError(t, distributor.renewLeases())
+	renewed := stored("service3")
+	assert.True(t, renewed.LeaseExpiry.After(*cordoned.LeaseExpiry))
+	assert.Equal(t, value.Epoch, renewed.Epoch)
+}
+
+func TestKubernetesNotReadyEviction(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	stor := storage.NewMemory()
+	for _, unit := range []string{"work1", "work2", "work3", "work4"} {
+		assert.NoError(t, stor.AddToList("units", unit))
+	}
+	pod := func(name, ip string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "robots", Labels: map[string]string{"app": "robots"}},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				PodIP:      ip,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		pod("robot-1", "10.0.0.1", corev1.ConditionTrue), pod("robot-2", "10.0.0.2", corev1.ConditionTrue),
+		pod("robot-3", "10.0.0.3", corev1.ConditionTrue), pod("robot-4", "10.0.0.4", corev1.ConditionTrue),
+	)
+	k, err := discovery.NewKubernetes(client, discovery.KubernetesOptions{
+		Source: discovery.SourcePods, Namespace: "robots", Selector: "app=robots", Port: "8080",
+	})
+	assert.NoError(t, err)
+	distributor, err := NewDistributor("table", "units", "robots", mocks.NewMockPinger(),
+		WithStorage(stor), WithTransport(&Transport{}), WithDiscovery(k), WithEvictionGuard(0.5))
+	assert.NoError(t, err)
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Len(t, distributor.currentTable(), 4)
+
+	// the rolling update makes most pods not ready at once, they are evicted without tripping the guard
+	ctx := context.Background()
+	for _, p := range []*corev1.Pod{pod("robot-2", "10.0.0.2", corev1.ConditionFalse),
+		pod("robot-3", "10.0.0.3", corev1.ConditionFalse), pod("robot-4", "10.0.0.4", corev1.ConditionFalse)} {
+		_, err = client.CoreV1().Pods("robots").UpdateStatus(ctx, p, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, distributor.LivenessCheck())
+	assert.Zero(t, testutil.ToFloat64(evictionGuardActive.WithLabelValues(distributor.serviceNamespace)))
+	assert.Len(t, distributor.DeadServices(), 3)
+	assert.Len(t, distributor.currentTable()["10.0.0.1:8080"], 4)
+}
+
+func TestPingersAuth(t *testing.T) {
+	_, err := newPingers(&config.Config{Pinger: grpcPinger, PingAuthSecret: "secret"}, time.Second)
+	assert.NoError(t, err)
+	_, err = newPingers(&config.Config{Pinger: httpPinger, PingAuthSecret: "secret"}, time.Second)
+	assert.Error(t, err)
+	_, err = newPingers(&config.Config{Pinger: grpcPinger, Pingers: "parsers=grpc-health", PingAuthSecret: "secret"}, time.Second)
+	assert.Error(t, err)
+	_, err = newPingers(&config.Config{Pinger: httpPinger}, time.Second)
+	assert.NoError(t, err)
+}
+
+func TestRunStops(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	distributor, err := CreateDistributor(TestTable["TestPutToMatchingTable"])
+	assert.NoError(t, err)
+	assert.NoError(t, WithLeases("testLeasesKey", time.Minute)(distributor))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errorsChan := make(chan error, 100)
+	done := make(chan struct{})
+	go func() {
+		distributor.RunContext(ctx, errorsChan)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run doesn't return when the context is done")
+	}
+}
@@ -0,0 +1,27 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// evictionGuarded reports whether failed services of total ones must not be evicted: if more than the max fraction
+// of services fail at once, the problem is likely on the Distributor side, e.g. its network is partitioned,
+// so all services and the matching table are kept until pings succeed again.
+func (d *Distributor) evictionGuarded(failed, total int) bool {
+	if d.maxEvictFraction == 0 || failed < 2 || float64(failed) <= d.maxEvictFraction*float64(total) {
+		evictionGuardActive.WithLabelValues(d.serviceNamespace).Set(0)
+		return false
+	}
+
+	logrus.Errorf("%d of %d services of the %s namespace failed pings, which exceeds %.0f%%: keep them and the matching table, check the network of the Distributor",
+		failed, total, d.serviceNamespace, d.maxEvictFraction*100)
+	evictionGuardActive.WithLabelValues(d.serviceNamespace).Set(1)
+	evictionGuardTriggers.WithLabelValues(d.serviceNamespace).Inc()
+
+	return true
+}
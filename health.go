@@ -0,0 +1,192 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultStorageCheckInterval = 5 * time.Second
+	defaultStorageCheckTimeout  = 2 * time.Second
+)
+
+// errStorageUnavailable is returned by the Distributor while the storage is unavailable.
+var errStorageUnavailable = errors.New("storage is unavailable")
+
+// StorageHealth monitors connectivity of the storage shared by Distributors. While the storage is unavailable,
+// Distributors don't evict services, and after it recovers they reload their state from it.
+type StorageHealth struct {
+	stor     storage.Storage
+	interval time.Duration
+	timeout  time.Duration
+
+	mu         sync.RWMutex // mutex for fields below
+	healthy    bool
+	since      time.Time // time of the last health change
+	lastErr    error     // error of the last failed check
+	recoveries int64     // number of recoveries after unavailability
+}
+
+// StorageStatus is the storage health reported by the debug status page.
+type StorageStatus struct {
+	Healthy    bool      `json:"healthy"`
+	Since      time.Time `json:"since"`
+	LastError  string    `json:"last_error,omitempty"`
+	Recoveries int64     `json:"recoveries"`
+}
+
+// NewStorageHealth creates the monitor of the storage available at start, which checks it every interval with timeout.
+func NewStorageHealth(stor storage.Storage, interval, timeout time.Duration) *StorageHealth {
+	if interval == 0 {
+		interval = defaultStorageCheckInterval
+	}
+	if timeout == 0 {
+		timeout = defaultStorageCheckTimeout
+	}
+	storageHealthy.Set(1)
+
+	return &StorageHealth{stor: stor, interval: interval, timeout: timeout, healthy: true, since: time.Now()}
+}
+
+// Run checks the storage until ctx is done.
+func (h *StorageHealth) Run(ctx context.Context) {
+	t := time.NewTicker(h.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			h.check()
+		}
+	}
+}
+
+func (h *StorageHealth) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	h.report(storage.Ping(ctx, h.stor))
+}
+
+// report updates the health by the result of a storage request, nil error means the storage is available.
+func (h *StorageHealth) report(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.lastErr = err
+	}
+	healthy := err == nil
+	if healthy == h.healthy {
+		return
+	}
+
+	h.healthy = healthy
+	h.since = time.Now()
+	if healthy {
+		h.recoveries++
+		storageHealthy.Set(1)
+		logrus.Info("storage is available again, resume evictions")
+	} else {
+		storageHealthy.Set(0)
+		logrus.Errorf("storage is unavailable, pause evictions: %s", err)
+	}
+}
+
+// Healthy reports whether the storage is available.
+func (h *StorageHealth) Healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.healthy
+}
+
+// Status returns the storage health.
+func (h *StorageHealth) Status() StorageStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	status := StorageStatus{Healthy: h.healthy, Since: h.since, Recoveries: h.recoveries}
+	if h.lastErr != nil {
+		status.LastError = h.lastErr.Error()
+	}
+
+	return status
+}
+
+// state returns whether the storage is available and the number of its recoveries.
+func (h *StorageHealth) state() (bool, int64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.healthy, h.recoveries
+}
+
+// checkStorage returns errStorageUnavailable while the storage is unavailable
+// and reconciles the Distributor with the storage after it recovers.
+func (d *Distributor) checkStorage() error {
+	if d.health == nil {
+		return nil
+	}
+
+	healthy, recoveries := d.health.state()
+	if !healthy {
+		pausedChecks.WithLabelValues(d.serviceNamespace).Inc()
+		return fmt.Errorf("liveness check of the %s namespace is paused: %w", d.serviceNamespace, errStorageUnavailable)
+	}
+	if recoveries == d.recoveries {
+		return nil
+	}
+
+	if err := d.reconcile(); err != nil {
+		d.storageFailed(err)
+		return err
+	}
+	d.recoveries = recoveries
+
+	return nil
+}
+
+// storageFailed marks the storage unavailable until the next successful check if it doesn't respond.
+func (d *Distributor) storageFailed(err error) {
+	if d.health == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.health.timeout)
+	defer cancel()
+	if pingErr := storage.Ping(ctx, d.Storage); pingErr != nil {
+		d.health.report(fmt.Errorf("%s: %w", err, pingErr))
+	}
+}
+
+// reconcile reloads the matching table and caches from the storage, since services and work units could change
+// while it was unavailable, and rebalances the table.
+func (d *Distributor) reconcile() error {
+	logrus.Infof("reconcile the %s namespace with the recovered storage", d.serviceNamespace)
+	for _, service := range d.serviceCache.all() {
+		d.serviceCache.del(service)
+	}
+	for _, unit := range d.workUnitsCache.all() {
+		d.workUnitsCache.del(unit)
+	}
+	if err := d.loadTable(); err != nil {
+		return err
+	}
+	if err := d.balance(); err != nil {
+		logrus.Warnf("rebalance the %s namespace after the storage recovery: %s", d.serviceNamespace, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/scientificideas/distributor/config"
+)
+
+const (
+	// layoutLegacy uses configured keys as they are: the services list is named by the services namespace,
+	// the work units list and the matching table are shared by all distribution groups.
+	layoutLegacy = "legacy"
+	// layoutHashTag prefixes all keys of a distribution group with the {<services namespace>}: hash tag,
+	// so they are in the same Redis Cluster slot and can be changed in one MULTI/EXEC transaction or Lua script.
+	layoutHashTag = "hashtag"
+)
+
+// groupKeys are storage keys of one distribution group, empty keys are disabled.
+type groupKeys struct {
+	Services    string // list of services
+	WorkUnits   string // list of work units
+	Table       string // matching table
+	LoadMetrics string // load of work units
+	Owners      string // reverse index of the matching table
+	Leases      string // leases of work units, the fencing tokens counter is <Leases>:token
+	Overrides   string // work units pinned to services
+	States      string // service states
+	Health      string // dead services
+}
+
+// newGroupKeys returns keys of the distribution group of the services namespace in the key layout.
+func newGroupKeys(configuration *config.Config, layout, serviceNamespace string) (groupKeys, error) {
+	switch layout {
+	case layoutLegacy:
+		return groupKeys{
+			Services:    serviceNamespace,
+			WorkUnits:   configuration.WorkunitsNamespace,
+			Table:       configuration.DistributionNamespace,
+			LoadMetrics: configuration.LoadMetricsKey,
+			Owners:      groupKey(configuration.UnitOwnersNamespace, serviceNamespace),
+			Leases:      groupKey(configuration.LeasesNamespace, serviceNamespace),
+			Overrides:   groupKey(configuration.OverridesNamespace, serviceNamespace),
+			States:      groupKey(configuration.ServiceStatesNamespace, serviceNamespace),
+			Health:      groupKey(configuration.ServiceHealthNamespace, serviceNamespace),
+		}, nil
+	case layoutHashTag:
+		tag := "{" + serviceNamespace + "}:"
+		tagged := func(key string) string {
+			if key == "" {
+				return ""
+			}
+			return tag + key
+		}
+		return groupKeys{
+			Services:    tag + "services",
+			WorkUnits:   tagged(configuration.WorkunitsNamespace),
+			Table:       tagged(configuration.DistributionNamespace),
+			LoadMetrics: tagged(configuration.LoadMetricsKey),
+			Owners:      tagged(configuration.UnitOwnersNamespace),
+			Leases:      tagged(configuration.LeasesNamespace),
+			Overrides:   tagged(configuration.OverridesNamespace),
+			States:      tagged(configuration.ServiceStatesNamespace),
+			Health:      tagged(configuration.ServiceHealthNamespace),
+		}, nil
+	default:
+		return groupKeys{}, fmt.Errorf("unknown key layout %q", layout)
+	}
+}
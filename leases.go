@@ -0,0 +1,181 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/scientificideas/distributor/pinger"
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const minLeaseDuration = time.Second // leases are renewed three times per lease duration
+
+// leasesEnabled reports whether the Distributor issues leases of work units.
+func (d *Distributor) leasesEnabled() bool {
+	return d.leasesKey != "" && d.leaseDuration > 0
+}
+
+// tokenKey returns the key of the fencing tokens counter in storage.
+func (d *Distributor) tokenKey() string {
+	return d.leasesKey + ":token"
+}
+
+// nextLeases returns leases of the table work units: work units kept by their services keep their leases,
+// other work units get new leases with new fencing tokens. If renew, all leases expire in the lease duration from now.
+// A work unit assigned to another service or taken from its owner, e.g. evicted with a dead service, keeps the lease
+// of the previous owner until it expires, so the new owner doesn't process the work unit while the previous one may still do it.
+func (d *Distributor) nextLeases(table map[string][]string, renew bool) (map[string]storage.Lease, error) {
+	d.leasesMu.RLock()
+	current := d.leases
+	d.leasesMu.RUnlock()
+
+	now := time.Now()
+	expiry := now.Add(d.leaseDuration)
+	next := make(map[string]storage.Lease)
+	var issued []string
+	for service, units := range table {
+		for _, unit := range units {
+			lease, ok := current[unit]
+			if ok && lease.Service != service && now.Before(lease.Expiry) {
+				next[unit] = lease // the new owner gets a lease after the renewal following the expiry
+				continue
+			}
+			if !ok || lease.Service != service {
+				issued = append(issued, unit)
+				lease = storage.Lease{Service: service}
+			}
+			if renew || lease.Token == 0 {
+				lease.Expiry = expiry
+			}
+			next[unit] = lease
+		}
+	}
+	for unit, lease := range current {
+		if _, ok := next[unit]; !ok && now.Before(lease.Expiry) {
+			next[unit] = lease
+		}
+	}
+	if len(issued) == 0 {
+		return next, nil
+	}
+
+	last, err := storage.IncrBy(d.Storage, d.tokenKey(), int64(len(issued)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(issued) // tokens are issued in a consistent order
+	for i, unit := range issued {
+		lease := next[unit]
+		lease.Token = last - int64(len(issued)-1-i)
+		next[unit] = lease
+	}
+	logrus.Debugf("issued %d leases of work units of the %s namespace", len(issued), d.serviceNamespace)
+
+	return next, nil
+}
+
+// leasesDiff returns encoded leases changed between current and next leases and work units absent in next leases.
+func leasesDiff(current, next map[string]storage.Lease) (map[string]interface{}, []string, error) {
+	changed := make(map[string]interface{})
+	for unit, lease := range next {
+		if currentLease, ok := current[unit]; ok && currentLease == lease {
+			continue
+		}
+		value, err := storage.EncodeLease(lease)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed[unit] = value
+	}
+
+	var removed []string
+	for unit := range current {
+		if _, ok := next[unit]; !ok {
+			removed = append(removed, unit)
+		}
+	}
+
+	return changed, removed, nil
+}
+
+// setLeases makes leases the current ones.
+func (d *Distributor) setLeases(leases map[string]storage.Lease) {
+	d.leasesMu.Lock()
+	d.leases = leases
+	d.leasesMu.Unlock()
+}
+
+// renewLeases extends leases of all work units of the matching table, work units without leases get new ones.
+// Lease expiries of JSON matching table values are updated in the same transaction.
+func (d *Distributor) renewLeases() error {
+	if !d.leasesEnabled() {
+		return nil
+	}
+
+	u, err := d.newTableUpdate(d.currentTable(), true)
+	if err != nil {
+		return err
+	}
+	if err = storage.UpdateMaps(d.Storage, u.set, u.del); err != nil {
+		return err
+	}
+	d.commitTable(u)
+
+	return nil
+}
+
+// loadLeases reads leases from storage, so fencing tokens of work units kept by their services survive restarts.
+func (d *Distributor) loadLeases() error {
+	if !d.leasesEnabled() {
+		return nil
+	}
+
+	stored, err := d.storedMap(d.leasesKey)
+	if err != nil {
+		return err
+	}
+
+	leases := make(map[string]storage.Lease, len(stored))
+	for unit, value := range stored {
+		lease, err := storage.DecodeLease(value)
+		if err != nil {
+			logrus.Warnf("reissue lease of work unit %s: %s", unit, err)
+			continue
+		}
+		leases[unit] = lease
+	}
+	d.setLeases(leases)
+
+	return nil
+}
+
+// serviceLeases returns leases of work units assigned to the service.
+func (d *Distributor) serviceLeases(service string) []pinger.Lease {
+	d.leasesMu.RLock()
+	defer d.leasesMu.RUnlock()
+
+	var res []pinger.Lease
+	for _, unit := range d.assignedUnits(service) {
+		if lease, ok := d.leases[unit]; ok && lease.Service == service {
+			res = append(res, pinger.Lease{Unit: unit, Token: lease.Token, Expiry: lease.Expiry})
+		}
+	}
+
+	return res
+}
+
+// Lease returns the lease of the work unit, false if the work unit has no lease.
+func (d *Distributor) Lease(unit string) (storage.Lease, bool) {
+	d.leasesMu.RLock()
+	defer d.leasesMu.RUnlock()
+
+	lease, ok := d.leases[unit]
+
+	return lease, ok
+}
@@ -0,0 +1,80 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// unitLoads collects processing load of work units reported by services in ping responses
+// and stored in the load metrics hash table. Reports from ping responses take precedence.
+func (d *Distributor) unitLoads() (map[string]float64, error) {
+	loads := make(map[string]float64)
+
+	if d.loadMetricsKey != "" {
+		metrics, err := storage.GetMap(d.Storage, d.loadMetricsKey)
+		if err != nil {
+			return nil, err
+		}
+		for unit, value := range metrics {
+			load, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				logrus.Warnf("invalid load %q of work unit %s in %s", value, unit, d.loadMetricsKey)
+				continue
+			}
+			loads[unit] = load
+		}
+	}
+
+	d.statusCache.mu.RLock()
+	for _, status := range d.statusCache.statuses {
+		for unit, load := range status.UnitLoads {
+			loads[unit] = load
+		}
+	}
+	d.statusCache.mu.RUnlock()
+
+	return loads, nil
+}
+
+// rebalanceByLoad moves work units from overloaded to underloaded services if the strategy supports it.
+// Work units are not rebalanced until the plan reaches its target table.
+func (d *Distributor) rebalanceByLoad() error {
+	lb, ok := d.strategy.(LoadBalancer)
+	if !ok {
+		return nil
+	}
+	if d.PlanStatus().Pending != 0 {
+		return nil
+	}
+
+	current := d.currentTable()
+	if len(current) == 0 {
+		return nil
+	}
+
+	loads, err := d.unitLoads()
+	if err != nil {
+		return err
+	}
+
+	// pinned work units and work units of cordoned services are never moved
+	free, pinned := d.splitPinned(current)
+	active, inactive := d.splitInactive(free)
+	table, moves := lb.Rebalance(active, loads)
+	if moves == 0 {
+		return nil
+	}
+	table = withPinned(withPinned(table, inactive), pinned)
+
+	logrus.Infof("move %d work units between services of the %s namespace by load", moves, d.serviceNamespace)
+	loadMoves.WithLabelValues(d.serviceNamespace).Add(float64(moves))
+
+	return d.writeTable(table)
+}
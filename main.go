@@ -6,9 +6,9 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"google.golang.org/grpc/keepalive"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,9 +17,10 @@ import (
 	"time"
 
 	"github.com/scientificideas/distributor/config"
-	grpcping "github.com/scientificideas/distributor/pinger/grpc"
+	"github.com/scientificideas/distributor/discovery"
 	"github.com/scientificideas/distributor/storage"
 	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
 )
 
 var Version = "undefined"
@@ -52,18 +53,6 @@ func main() {
 
 	logrus.SetLevel(lvl)
 
-	if configuration.KATime == 0 {
-		configuration.KATime = 10 * time.Second
-	}
-	if configuration.KATimeout == 0 {
-		configuration.KATimeout = 20 * time.Second
-	}
-	p := grpcping.NewPinger(keepalive.ClientParameters{
-		Time:                configuration.KATime,    // default infinity
-		Timeout:             configuration.KATimeout, // default 20s
-		PermitWithoutStream: configuration.KAPermitWithoutStream,
-	})
-
 	pingTimeout, err := time.ParseDuration(configuration.PingTimeout)
 	if err != nil {
 		logrus.Fatal(err)
@@ -74,39 +63,108 @@ func main() {
 		logrus.Fatal(err)
 	}
 
-	logrus.Info("connecting to Redis...")
+	pingers, err := newPingers(configuration, pingTimeout)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	storageInstance, err := storage.NewRedis(
-		configuration.RedisPass,
-		strings.Split(configuration.RedisAddrs, ","),
-		configuration.RedisTLS,
-		strings.Split(configuration.RedisRootCACerts, ","),
-	)
+	var strategy Strategy
+	switch configuration.Strategy {
+	case "deterministic":
+		strategy = DeterministicStrategy{}
+	case "load-aware":
+		strategy = LoadAwareStrategy{Hysteresis: configuration.LoadHysteresis, MaxMoves: configuration.MaxLoadMoves}
+	default:
+		logrus.Fatalf("unknown distribution strategy %q", configuration.Strategy)
+	}
+
+	tableFormat, err := storage.ParseTableFormat(configuration.TableFormat)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	storageInstance, err := newStorage(configuration)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	logrus.Info("successfully connected to Redis")
+	if configuration.MigrateKeys {
+		for _, serviceNamespace := range strings.Split(configuration.ServiceStorageNamespace, ",") {
+			if err = migrateGroupKeys(storageInstance, configuration, serviceNamespace); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		logrus.Infof("keys are migrated to the %s layout", configuration.KeyLayout)
+		return
+	}
+
+	health := NewStorageHealth(storageInstance, configuration.StorageCheckInterval, configuration.StorageCheckTimeout)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go health.Run(ctx)
+
+	var kubeClient kubernetes.Interface
+	if configuration.Discovery == "kubernetes" {
+		if kubeClient, err = discovery.NewKubernetesClient(configuration.Kubeconfig); err != nil {
+			logrus.Fatal(err)
+		}
+	}
 
+	var (
+		distributors []*Distributor
+		allKeys      []groupKeys
+	)
 	for _, serviceNamespace := range strings.Split(configuration.ServiceStorageNamespace, ",") {
+		p, err := pingers.forNamespace(serviceNamespace)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		keys, err := newGroupKeys(configuration, configuration.KeyLayout, serviceNamespace)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		allKeys = append(allKeys, keys)
+		disc, err := newDiscovery(configuration, storageInstance, kubeClient, keys.Services, serviceNamespace)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
 		distributor, err := NewDistributor(
-			configuration.DistributionNamespace,
-			configuration.WorkunitsNamespace,
+			keys.Table,
+			keys.WorkUnits,
 			serviceNamespace,
 			p,
 			WithStorage(storageInstance),
+			WithServicesKey(keys.Services),
+			WithDiscovery(disc),
+			WithStorageHealth(health),
+			WithEvictionGuard(configuration.MaxEvictFraction),
+			WithDeadServices(keys.Health, configuration.DeadServiceGC),
 			WithTransport(&Transport{
 				PingTimeout:  pingTimeout,
 				PollInterval: pollInterval,
 			}),
+			WithStrategy(strategy, configuration.RebalanceInterval),
+			WithLoadMetrics(keys.LoadMetrics),
+			WithTableFormat(tableFormat),
+			WithOwnersIndex(keys.Owners),
+			WithLeases(keys.Leases, configuration.LeaseDuration),
+			WithOverrides(keys.Overrides),
+			WithServiceStates(keys.States, configuration.KeepCordonedUnits),
+			WithBudget(Budget{
+				MaxMoves:         configuration.MaxMovesPerStep,
+				MaxChangePercent: configuration.MaxChangePercent,
+				Interval:         configuration.PlanInterval,
+			}),
 		)
 		if err != nil {
 			logrus.Fatal(err)
 		}
 
+		distributors = append(distributors, distributor)
 		errorsChan := make(chan error, 100)
 
-		go distributor.Run(errorsChan)
+		go distributor.RunContext(ctx, errorsChan)
 		go func() {
 			for err := range errorsChan {
 				logrus.Warn(err)
@@ -114,6 +172,25 @@ func main() {
 		}()
 	}
 
+	// expose progress of work units migration to the target matching tables
+	http.HandleFunc("/plan", planHandler(distributors))
+	// expose probes and the status of distribution groups
+	probes := newProbes(health, distributors, configuration.StallTimeout)
+	http.HandleFunc("/healthz", probes.healthz)
+	http.HandleFunc("/readyz", probes.readyz)
+	http.HandleFunc("/debug/status", probes.status)
+	// expose admin API
+	http.Handle("/admin/", newAdmin(distributors, configuration.AdminToken))
+	// expose the embedded storage to services which can't access it
+	if configuration.StorageType == "bolt" || configuration.StorageType == "memory" {
+		api, err := newStorageAPI(storageInstance, configuration.StorageAPIToken, allKeys)
+		if err != nil {
+			logrus.Warnf("storage HTTP API is disabled: %s", err)
+		} else {
+			http.Handle("/storage/", api)
+		}
+	}
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(
 		signalChan,
@@ -125,3 +202,114 @@ func main() {
 	logrus.Infof("Got signal: %s", s.String())
 	logrus.Info("shutdown")
 }
+
+// groupKey returns storage key of the distribution group data stored under prefix.
+func groupKey(prefix, serviceNamespace string) string {
+	if prefix == "" {
+		return ""
+	}
+
+	return prefix + ":" + serviceNamespace
+}
+
+// newDiscovery returns the configured source of services of the distribution group. Kubernetes services
+// are discovered from the Service named as the services namespace or from pods labeled with it.
+func newDiscovery(configuration *config.Config, stor storage.Storage, kubeClient kubernetes.Interface, servicesKey, serviceNamespace string) (discovery.Discovery, error) {
+	switch configuration.Discovery {
+	case "storage":
+		return discovery.NewList(stor, servicesKey), nil
+	case "kubernetes":
+		source, err := discovery.ParseSource(configuration.KubeSource)
+		if err != nil {
+			return nil, err
+		}
+		opts := discovery.KubernetesOptions{
+			Source:    source,
+			Namespace: configuration.KubeNamespace,
+			Port:      configuration.KubePort,
+		}
+		if source == discovery.SourcePods {
+			opts.Selector = configuration.KubeGroupLabel + "=" + serviceNamespace
+		} else {
+			opts.Service = serviceNamespace
+		}
+		return discovery.NewKubernetes(kubeClient, opts)
+	default:
+		return nil, fmt.Errorf("unknown discovery %q", configuration.Discovery)
+	}
+}
+
+// newStorage connects to the storage of the configured type.
+func newStorage(configuration *config.Config) (storage.Storage, error) {
+	switch configuration.StorageType {
+	case "redis":
+		logrus.Info("connecting to Redis...")
+		mode, err := storage.ParseRedisMode(configuration.RedisMode)
+		if err != nil {
+			return nil, err
+		}
+		stor, err := storage.NewRedisWithOptions(storage.RedisOptions{
+			Mode:             mode,
+			Addrs:            strings.Split(configuration.RedisAddrs, ","),
+			MasterName:       configuration.RedisMasterName,
+			SentinelPassword: configuration.RedisSentinelPass,
+			Username:         configuration.RedisUsername,
+			Password:         configuration.RedisPass,
+			DB:               configuration.RedisDB,
+			PoolSize:         configuration.RedisPoolSize,
+			DialTimeout:      configuration.RedisDialTimeout,
+			ReadTimeout:      configuration.RedisReadTimeout,
+			WriteTimeout:     configuration.RedisWriteTimeout,
+			TLS:              configuration.RedisTLS,
+			RootCAs:          strings.Split(configuration.RedisRootCACerts, ","),
+			ClientCert:       configuration.RedisClientCert,
+			ClientKey:        configuration.RedisClientKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		logrus.Info("successfully connected to Redis")
+
+		return stor, nil
+	case "etcd":
+		logrus.Info("connecting to etcd...")
+		stor, err := storage.NewEtcd(
+			strings.Split(configuration.EtcdEndpoints, ","),
+			configuration.EtcdUsername,
+			configuration.EtcdPassword,
+			configuration.EtcdTLS,
+			strings.Split(configuration.EtcdRootCACerts, ","),
+			configuration.EtcdPrefix,
+		)
+		if err != nil {
+			return nil, err
+		}
+		stor.MaxTxnOps = configuration.EtcdMaxTxnOps
+		logrus.Info("successfully connected to etcd")
+
+		return stor, nil
+	case "postgres":
+		logrus.Info("connecting to PostgreSQL...")
+		stor, err := storage.NewPostgres(configuration.PostgresDSN)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Info("successfully connected to PostgreSQL")
+
+		return stor, nil
+	case "bolt":
+		stor, err := storage.NewBolt(configuration.BoltPath)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("opened embedded storage %s", configuration.BoltPath)
+
+		return stor, nil
+	case "memory":
+		logrus.Warn("using in-memory storage, the matching table is lost on restart")
+
+		return storage.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", configuration.StorageType)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// foreignUnits is the number of work units processed by the service without being assigned to it.
+	foreignUnits = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distributor_foreign_units",
+		Help: "Number of work units reported by the service as processed but not assigned to it.",
+	}, []string{"namespace", "service"})
+
+	// loadMoves is the number of work units moved between services by load rebalancing.
+	loadMoves = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "distributor_load_moves_total",
+		Help: "Number of work units moved from overloaded to underloaded services.",
+	}, []string{"namespace"})
+
+	// planTotalMoves is the number of work units moves required to reach the current plan target.
+	planTotalMoves = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distributor_plan_total_moves",
+		Help: "Number of work units moves required to reach the target table when it was set.",
+	}, []string{"namespace"})
+
+	// planPendingMoves is the number of work units moves left to reach the current plan target.
+	planPendingMoves = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distributor_plan_pending_moves",
+		Help: "Number of work units moves left to reach the target table.",
+	}, []string{"namespace"})
+
+	// planMoves is the number of work units moved by plan steps.
+	planMoves = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "distributor_plan_moves_total",
+		Help: "Number of work units moved between live services by plan steps.",
+	}, []string{"namespace"})
+
+	// storageHealthy is 1 if the storage is available and 0 otherwise.
+	storageHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "distributor_storage_healthy",
+		Help: "Whether the storage is available (1) or not (0), services are not evicted while it's unavailable.",
+	})
+
+	// pausedChecks is the number of liveness checks skipped because the storage was unavailable.
+	pausedChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "distributor_paused_liveness_checks_total",
+		Help: "Number of liveness checks skipped because the storage was unavailable.",
+	}, []string{"namespace"})
+
+	// evictionGuardActive is 1 if the eviction guard kept failed services in the last liveness check.
+	evictionGuardActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distributor_eviction_guard_active",
+		Help: "Whether too many services failed pings in the last liveness check, so none of them were evicted (1) or not (0).",
+	}, []string{"namespace"})
+
+	// evictionGuardTriggers is the number of liveness checks in which the eviction guard kept failed services.
+	evictionGuardTriggers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "distributor_eviction_guard_triggered_total",
+		Help: "Number of liveness checks in which too many services failed pings, so none of them were evicted.",
+	}, []string{"namespace"})
+
+	// deadServicesGauge is the number of registered services failing pings.
+	deadServicesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distributor_dead_services",
+		Help: "Number of registered services failing pings, they get no work until they respond.",
+	}, []string{"namespace"})
+
+	// invalidUnits is the number of work units skipped because of invalid IDs.
+	invalidUnits = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distributor_invalid_units",
+		Help: "Number of work units in storage skipped because their IDs can't be stored in the matching table.",
+	}, []string{"namespace"})
+)
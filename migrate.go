@@ -0,0 +1,200 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/scientificideas/distributor/config"
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// migrateGroupKeys copies data of the distribution group of the services namespace from the legacy keys
+// to the keys of the configured layout. Target keys with data are not overwritten unless the migration is forced.
+func migrateGroupKeys(stor storage.Storage, configuration *config.Config, serviceNamespace string) error {
+	if configuration.KeyLayout == layoutLegacy {
+		return fmt.Errorf("keys are in the %s layout already, set the target layout", layoutLegacy)
+	}
+	from, err := newGroupKeys(configuration, layoutLegacy, serviceNamespace)
+	if err != nil {
+		return err
+	}
+	to, err := newGroupKeys(configuration, configuration.KeyLayout, serviceNamespace)
+	if err != nil {
+		return err
+	}
+	if !configuration.MigrateForce {
+		key, err := usedTargetKey(stor, from, to)
+		if err != nil {
+			return err
+		}
+		if key != "" {
+			return fmt.Errorf("key %s of the %s namespace has data already, it may be newer than the legacy one, "+
+				"set -migrate-force=true to overwrite it", key, serviceNamespace)
+		}
+	}
+	logrus.Infof("migrate keys of the %s namespace to the %s layout", serviceNamespace, configuration.KeyLayout)
+
+	return migrateKeys(stor, from, to)
+}
+
+// usedTargetKey returns the first key of to that has data and differs from the key of from, empty if there is none.
+func usedTargetKey(stor storage.Storage, from, to groupKeys) (string, error) {
+	for _, list := range [][2]string{{from.Services, to.Services}, {from.WorkUnits, to.WorkUnits}} {
+		if list[1] == "" || list[0] == list[1] {
+			continue
+		}
+		items, err := stor.GetList(list[1])
+		if err != nil {
+			return "", err
+		}
+		if len(items) != 0 {
+			return list[1], nil
+		}
+	}
+
+	for _, m := range [][2]string{
+		{from.Table, to.Table},
+		{from.LoadMetrics, to.LoadMetrics},
+		{from.Owners, to.Owners},
+		{from.Leases, to.Leases},
+		{from.Overrides, to.Overrides},
+		{from.States, to.States},
+		{from.Health, to.Health},
+	} {
+		if m[1] == "" || m[0] == m[1] {
+			continue
+		}
+		fields, err := storage.GetMap(stor, m[1])
+		if err != nil {
+			return "", err
+		}
+		if len(fields) != 0 {
+			return m[1], nil
+		}
+	}
+
+	if to.Leases == "" || from.Leases == to.Leases {
+		return "", nil
+	}
+	token, err := storage.IncrBy(stor, to.Leases+":token", 0)
+	if errors.Is(err, storage.ErrNotSupported) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if token != 0 {
+		return to.Leases + ":token", nil
+	}
+
+	return "", nil
+}
+
+// migrateKeys copies data of the distribution group from keys of one layout to keys of another one.
+// Source keys are kept, so the Distributor can be rolled back. Copying again adds absent list items,
+// replaces maps with the source ones and never decreases the fencing tokens counter.
+// Only services of the group are copied from the matching table, since the legacy one is shared by groups.
+func migrateKeys(stor storage.Storage, from, to groupKeys) error {
+	for _, list := range [][2]string{{from.Services, to.Services}, {from.WorkUnits, to.WorkUnits}} {
+		if err := migrateList(stor, list[0], list[1]); err != nil {
+			return err
+		}
+	}
+
+	services, err := stor.GetList(to.Services)
+	if err != nil {
+		return err
+	}
+	if err = migrateMap(stor, from.Table, to.Table, services); err != nil {
+		return err
+	}
+	for _, m := range [][2]string{
+		{from.LoadMetrics, to.LoadMetrics},
+		{from.Owners, to.Owners},
+		{from.Leases, to.Leases},
+		{from.Overrides, to.Overrides},
+		{from.States, to.States},
+		{from.Health, to.Health},
+	} {
+		if err = migrateMap(stor, m[0], m[1], nil); err != nil {
+			return err
+		}
+	}
+
+	if from.Leases == "" || to.Leases == "" || from.Leases == to.Leases {
+		return nil
+	}
+
+	return migrateCounter(stor, from.Leases+":token", to.Leases+":token")
+}
+
+func migrateList(stor storage.Storage, from, to string) error {
+	if from == "" || to == "" || from == to {
+		return nil
+	}
+
+	items, err := stor.GetList(from)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err = storage.AddToList(stor, to, item); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("copied %d items of the %s list to %s", len(items), from, to)
+
+	return nil
+}
+
+// migrateMap copies the map, only the fields if they are set.
+func migrateMap(stor storage.Storage, from, to string, fields []string) error {
+	if from == "" || to == "" || from == to {
+		return nil
+	}
+
+	m, err := storage.GetMap(stor, from)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{}, len(m))
+	for field, value := range m {
+		if fields == nil || includes(fields, field) {
+			values[field] = value
+		}
+	}
+	if err = storage.ReplaceMap(stor, to, values); err != nil {
+		return err
+	}
+	logrus.Infof("copied %d fields of the %s map to %s", len(values), from, to)
+
+	return nil
+}
+
+func migrateCounter(stor storage.Storage, from, to string) error {
+	source, err := storage.IncrBy(stor, from, 0)
+	if errors.Is(err, storage.ErrNotSupported) {
+		return nil // the storage can't keep fencing tokens, so there are no leases to migrate
+	}
+	if err != nil {
+		return err
+	}
+	target, err := storage.IncrBy(stor, to, 0)
+	if err != nil {
+		return err
+	}
+	if source > target {
+		if _, err = storage.IncrBy(stor, to, source-target); err != nil {
+			return err
+		}
+		logrus.Infof("copied the %s counter to %s", from, to)
+	}
+
+	return nil
+}
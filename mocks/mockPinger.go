@@ -9,9 +9,14 @@ import (
 	"context"
 	"fmt"
 	"strings"
+
+	"github.com/scientificideas/distributor/pinger"
 )
 
-type MockPinger struct{}
+// MockPinger fails pings of services with "bad" in their URLs, or of all services if FailAll is set.
+type MockPinger struct {
+	FailAll bool
+}
 
 func NewMockPinger() *MockPinger {
 	return &MockPinger{}
@@ -22,9 +27,27 @@ func (p *MockPinger) Init(_ ...string) error {
 }
 
 func (p *MockPinger) Ping(_ context.Context, url string) error {
-	if strings.Contains(url, "bad") {
+	if p.FailAll || strings.Contains(url, "bad") {
 		return fmt.Errorf("bad request")
 	}
 
 	return nil
 }
+
+// MockStatusPinger reports Statuses of services, services without status don't report it.
+type MockStatusPinger struct {
+	MockPinger
+	Statuses map[string]*pinger.Status
+}
+
+func NewMockStatusPinger() *MockStatusPinger {
+	return &MockStatusPinger{Statuses: make(map[string]*pinger.Status)}
+}
+
+func (p *MockStatusPinger) PingStatus(ctx context.Context, url string) (*pinger.Status, error) {
+	if err := p.Ping(ctx, url); err != nil {
+		return nil, err
+	}
+
+	return p.Statuses[url], nil
+}
@@ -7,8 +7,15 @@ package mocks
 
 import (
 	"strings"
+
+	"github.com/scientificideas/distributor/storage"
 )
 
+var _ storage.Storage = (*MockStorage)(nil)
+
+// MockStorage keeps lists and a single matching table in maps, the map keys of SetMap, DelFromMap and GetMapField are ignored.
+//
+// Deprecated: use storage.NewMemory, which is safe for concurrent use and keeps maps and optional capabilities.
 type MockStorage struct {
 	Lists     map[string][]string
 	HashTable map[string]string
@@ -6,6 +6,10 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/scientificideas/distributor/discovery"
 	"github.com/scientificideas/distributor/storage"
 )
 
@@ -34,6 +38,20 @@ func WithRedis(password string, addresses []string, withTLS bool, redisRootCACer
 	}
 }
 
+// WithRedisOptions injects Redis storage client connected with the options into the Distributor.
+func WithRedisOptions(opts storage.RedisOptions) Option {
+	return func(d *Distributor) error {
+		stor, err := storage.NewRedisWithOptions(opts)
+		if err != nil {
+			return err
+		}
+
+		d.Storage = stor
+
+		return nil
+	}
+}
+
 // WithStorage injects any Storage implementation into the Distributor.
 func WithStorage(stor storage.Storage) Option {
 	return func(d *Distributor) error {
@@ -42,3 +60,150 @@ func WithStorage(stor storage.Storage) Option {
 		return nil
 	}
 }
+
+// WithStrategy sets the work distribution strategy of the Distributor, DeterministicStrategy by default.
+// LoadBalancer strategies also rebalance work units by load at rebalanceInterval (30s if zero).
+func WithStrategy(strategy Strategy, rebalanceInterval time.Duration) Option {
+	return func(d *Distributor) error {
+		d.strategy = strategy
+		d.rebalanceInterval = rebalanceInterval
+
+		return nil
+	}
+}
+
+// WithLoadMetrics makes the Distributor read work units load from the storage hash table stored for key,
+// where every field is a work unit and every value is its load.
+func WithLoadMetrics(key string) Option {
+	return func(d *Distributor) error {
+		d.loadMetricsKey = key
+
+		return nil
+	}
+}
+
+// WithBudget limits the number of work units moved between live services at once.
+// New distributions are applied in several steps made at budget.Interval (10s if zero).
+func WithBudget(budget Budget) Option {
+	return func(d *Distributor) error {
+		d.budget = budget
+
+		return nil
+	}
+}
+
+// WithOverrides makes the Distributor pin work units to services according to the storage hash table stored for key,
+// where every field is a work unit and every value is a service.
+func WithOverrides(key string) Option {
+	return func(d *Distributor) error {
+		d.overridesKey = key
+
+		return nil
+	}
+}
+
+// WithServiceStates makes the Distributor honor service states set by operators in the storage hash table stored for key,
+// where every field is a service and every value is its state. Cordoned services keep their work units if keepCordonedUnits.
+func WithServiceStates(key string, keepCordonedUnits bool) Option {
+	return func(d *Distributor) error {
+		d.statesKey = key
+		d.keepCordonedUnits = keepCordonedUnits
+
+		return nil
+	}
+}
+
+// WithTableFormat sets the encoding of matching table values, storage.FormatLegacy by default.
+// storage.FormatLegacy keeps comma-joined work units for old consumers.
+func WithTableFormat(format storage.TableFormat) Option {
+	return func(d *Distributor) error {
+		if _, err := storage.ParseTableFormat(string(format)); err != nil {
+			return err
+		}
+		d.tableFormat = format
+
+		return nil
+	}
+}
+
+// WithStorageHealth makes the Distributor pause liveness checks, and so evictions of services, while the storage
+// is unavailable and reload its state from the storage after the storage recovers.
+func WithStorageHealth(h *StorageHealth) Option {
+	return func(d *Distributor) error {
+		d.health = h
+		_, d.recoveries = h.state()
+
+		return nil
+	}
+}
+
+// WithEvictionGuard makes the Distributor keep all services and the matching table if more than maxFraction
+// of services fail pings in one liveness check, since the Distributor's own network is likely partitioned then.
+// Single failed services are always evicted, the guard is disabled if maxFraction is zero.
+func WithEvictionGuard(maxFraction float64) Option {
+	return func(d *Distributor) error {
+		if maxFraction < 0 || maxFraction > 1 {
+			return fmt.Errorf("max fraction of evicted services must be from 0 to 1, got %v", maxFraction)
+		}
+		d.maxEvictFraction = maxFraction
+
+		return nil
+	}
+}
+
+// WithDiscovery sets the source of services of the Distributor, services register themselves
+// in the storage list by default.
+func WithDiscovery(disc discovery.Discovery) Option {
+	return func(d *Distributor) error {
+		d.discovery = disc
+
+		return nil
+	}
+}
+
+// WithDeadServices makes the Distributor store registered services failing pings in the storage hash table
+// stored for key (not stored if empty), where every field is a service and every value is the time of its first
+// failed ping, and delete registrations of services failing pings longer than gcAfter (never if zero).
+func WithDeadServices(key string, gcAfter time.Duration) Option {
+	return func(d *Distributor) error {
+		d.deadKey = key
+		d.deadGCAfter = gcAfter
+
+		return nil
+	}
+}
+
+// WithServicesKey sets the key of the storage list with services, the services namespace by default.
+func WithServicesKey(key string) Option {
+	return func(d *Distributor) error {
+		d.servicesKey = key
+
+		return nil
+	}
+}
+
+// WithOwnersIndex makes the Distributor maintain the reverse index of the matching table in the storage hash table
+// stored for key, where every field is a work unit and every value is its owner.
+func WithOwnersIndex(key string) Option {
+	return func(d *Distributor) error {
+		d.ownersKey = key
+
+		return nil
+	}
+}
+
+// WithLeases makes the Distributor issue leases of work units with fencing tokens, store them in the storage hash table
+// stored for key, where every field is a work unit and every value is its lease, and send them to services in pings.
+// Leases expire in duration unless renewed, leases are not issued if duration is zero.
+// The storage must be a storage.Counter to issue fencing tokens.
+func WithLeases(key string, duration time.Duration) Option {
+	return func(d *Distributor) error {
+		if duration != 0 && duration < minLeaseDuration {
+			return fmt.Errorf("lease duration must be zero or at least %s, got %s", minLeaseDuration, duration)
+		}
+		d.leasesKey = key
+		d.leaseDuration = duration
+
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Overrides returns work units pinned to services by operators: work unit -> service.
+func (d *Distributor) Overrides() (map[string]string, error) {
+	if d.overridesKey == "" {
+		return map[string]string{}, nil
+	}
+
+	return storage.GetMap(d.Storage, d.overridesKey)
+}
+
+// SetOverride pins the work unit to the service. The service must be registered and alive,
+// the work unit must be in the work units list.
+func (d *Distributor) SetOverride(unit, service string) error {
+	if d.overridesKey == "" {
+		return fmt.Errorf("overrides are disabled for the %s namespace", d.serviceNamespace)
+	}
+
+	services, err := d.Services()
+	if err != nil {
+		return err
+	}
+	if !includes(services, service) || !d.serviceCache.exist(service) {
+		return fmt.Errorf("service %s is not alive in the %s namespace", service, d.serviceNamespace)
+	}
+	units, err := d.RingMembers()
+	if err != nil {
+		return err
+	}
+	if !includes(units, unit) {
+		return fmt.Errorf("work unit %s is not in the work units list of the %s namespace", unit, d.serviceNamespace)
+	}
+
+	return d.Storage.SetMap(d.overridesKey, map[string]interface{}{unit: service})
+}
+
+// DelOverride unpins the work unit, so it's distributed automatically again.
+func (d *Distributor) DelOverride(unit string) error {
+	if d.overridesKey == "" {
+		return fmt.Errorf("overrides are disabled for the %s namespace", d.serviceNamespace)
+	}
+
+	return d.Storage.DelFromMap(d.overridesKey, unit)
+}
+
+// pinnedUnits returns work units pinned to the given services: service -> work units.
+// Work units pinned to other services (not alive or draining) are distributed automatically.
+func (d *Distributor) pinnedUnits(services, units []string) (map[string][]string, error) {
+	overrides, err := d.Overrides()
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := make(map[string][]string)
+	for _, unit := range units {
+		service, ok := overrides[unit]
+		if !ok {
+			continue
+		}
+		if !includes(services, service) {
+			logrus.Warnf("work unit %s is pinned to service %s which can't get work, distribute it automatically", unit, service)
+			continue
+		}
+		pinned[service] = append(pinned[service], unit)
+	}
+
+	d.overridesMu.Lock()
+	d.overrides = overrides
+	d.overridesMu.Unlock()
+
+	return pinned, nil
+}
+
+// overridesChanged reports whether overrides in storage differ from the ones applied by the last distribution.
+func (d *Distributor) overridesChanged() (bool, error) {
+	overrides, err := d.Overrides()
+	if err != nil {
+		return false, err
+	}
+
+	d.overridesMu.RLock()
+	defer d.overridesMu.RUnlock()
+
+	return !equalMaps(overrides, d.overrides), nil
+}
+
+// isPinned reports whether the work unit is pinned to the service by the last distribution.
+func (d *Distributor) isPinned(unit, service string) bool {
+	d.overridesMu.RLock()
+	defer d.overridesMu.RUnlock()
+
+	return d.overrides[unit] == service
+}
+
+// splitPinned splits the table into tables of automatically distributed and pinned work units.
+func (d *Distributor) splitPinned(table map[string][]string) (map[string][]string, map[string][]string) {
+	free := make(map[string][]string, len(table))
+	pinned := make(map[string][]string)
+	for service, units := range table {
+		free[service] = []string{}
+		for _, unit := range units {
+			if d.isPinned(unit, service) {
+				pinned[service] = append(pinned[service], unit)
+			} else {
+				free[service] = append(free[service], unit)
+			}
+		}
+	}
+
+	return free, pinned
+}
+
+// withPinned adds pinned work units to the table.
+func withPinned(table, pinned map[string][]string) map[string][]string {
+	for service, units := range pinned {
+		table[service] = append(table[service], units...)
+	}
+
+	return table
+}
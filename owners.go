@@ -0,0 +1,108 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/scientificideas/distributor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// UnitOwner returns the service owning the work unit. The owner is read from the reverse index in storage
+// if it's enabled and the storage can read it, otherwise from the matching table last written by the Distributor.
+func (d *Distributor) UnitOwner(unit string) (storage.UnitOwner, error) {
+	if d.ownersKey != "" {
+		owner, err := storage.GetUnitOwner(d.Storage, d.ownersKey, unit)
+		if !errors.Is(err, storage.ErrNotSupported) {
+			return owner, err
+		}
+	}
+
+	d.tableMu.RLock()
+	defer d.tableMu.RUnlock()
+
+	owner, ok := unitOwners(d.table, d.epochs)[unit]
+	if !ok {
+		return storage.UnitOwner{}, storage.ErrNotFound
+	}
+
+	return owner, nil
+}
+
+// unitOwners returns the reverse index of the matching table: work unit -> owner.
+func unitOwners(table map[string][]string, epochs map[string]int64) map[string]storage.UnitOwner {
+	res := make(map[string]storage.UnitOwner)
+	for service, units := range table {
+		for _, unit := range units {
+			res[unit] = storage.UnitOwner{Service: service, Epoch: epochs[service]}
+		}
+	}
+
+	return res
+}
+
+// ownersDiff returns encoded reverse index values changed between current and next indexes
+// and work units absent in the next index.
+func ownersDiff(current, next map[string]storage.UnitOwner) (map[string]interface{}, []string, error) {
+	changed := make(map[string]interface{})
+	for unit, owner := range next {
+		if currentOwner, ok := current[unit]; ok && currentOwner == owner {
+			continue
+		}
+		value, err := storage.EncodeUnitOwner(owner)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed[unit] = value
+	}
+
+	var removed []string
+	for unit := range current {
+		if _, ok := next[unit]; !ok {
+			removed = append(removed, unit)
+		}
+	}
+
+	return changed, removed, nil
+}
+
+// syncOwners makes the reverse index in storage match the current matching table,
+// e.g. if the index was enabled after the table had been written.
+func (d *Distributor) syncOwners() error {
+	if d.ownersKey == "" {
+		return nil
+	}
+
+	stored, err := d.storedMap(d.ownersKey)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]storage.UnitOwner, len(stored))
+	for unit, value := range stored {
+		owner, err := storage.DecodeUnitOwner(value)
+		if err != nil {
+			logrus.Warnf("rewrite owner of work unit %s: %s", unit, err) // the zero owner differs from any valid one
+		}
+		current[unit] = owner
+	}
+
+	d.tableMu.RLock()
+	next := unitOwners(d.table, d.epochs)
+	d.tableMu.RUnlock()
+
+	changed, removed, err := ownersDiff(current, next)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	logrus.Infof("update owners of %d work units of the %s namespace", len(changed)+len(removed), d.serviceNamespace)
+
+	return storage.UpdateMaps(d.Storage, map[string]map[string]interface{}{d.ownersKey: changed}, map[string][]string{d.ownersKey: removed})
+}
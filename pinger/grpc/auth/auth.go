@@ -0,0 +1,212 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	timestampKey        = "x-distributor-timestamp" // request metadata: unix time of the request in nanoseconds
+	nonceKey            = "x-distributor-nonce"     // request metadata: random value making every request unique
+	signatureKey        = "x-distributor-signature" // request metadata: signature of the method, the target, the timestamp, the nonce and the request
+	serviceIDKey        = "x-service-id"            // response trailer: identity of the answering service
+	serviceSignatureKey = "x-service-signature"     // response trailer: signature of the identity and the response bound to the request
+
+	defaultMaxSkew = 30 * time.Second // default max difference between the request timestamp and the server clock
+)
+
+// HMAC authenticates ping RPCs with a secret shared by the Distributor and the services.
+// The Distributor signs every request together with the target service and the request message, the service checks
+// the signature and answers with its identity and the response message signed together with the request signature,
+// so neither message can be modified and a captured response can't be replayed by another address.
+// The service accepts every signed request once and only if it's addressed to it, so a captured request
+// can't be replayed to the same or another service within the allowed skew.
+type HMAC struct {
+	secret  []byte
+	maxSkew time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // signatures of accepted requests -> time until they are in the allowed window
+}
+
+// NewHMAC creates HMAC authenticator. maxSkew limits the age of accepted requests, 30s if zero.
+func NewHMAC(secret []byte, maxSkew time.Duration) (*HMAC, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty ping authentication secret")
+	}
+	if maxSkew == 0 {
+		maxSkew = defaultMaxSkew
+	}
+
+	return &HMAC{secret: secret, maxSkew: maxSkew, seen: make(map[string]time.Time)}, nil
+}
+
+func (h *HMAC) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *HMAC) valid(signature string, parts ...string) bool {
+	return hmac.Equal([]byte(signature), []byte(h.sign(parts...)))
+}
+
+// firstUse remembers the request signature until the request leaves the allowed window,
+// returns false if the signature was already used.
+func (h *HMAC) firstUse(signature string, timestamp time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for s, expiry := range h.seen {
+		if now.After(expiry) {
+			delete(h.seen, s)
+		}
+	}
+	if _, ok := h.seen[signature]; ok {
+		return false
+	}
+	h.seen[signature] = timestamp.Add(h.maxSkew)
+
+	return true
+}
+
+// digest returns the hash of the deterministically serialized message.
+func digest(msg interface{}) (string, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("can't sign message of type %T", msg)
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func nonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// UnaryClientInterceptor signs outgoing requests for the dialed target and verifies that the response
+// was signed by the service registered under it.
+func (h *HMAC) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+		n, err := nonce()
+		if err != nil {
+			return err
+		}
+		reqDigest, err := digest(req)
+		if err != nil {
+			return err
+		}
+		signature := h.sign("request", method, cc.Target(), timestamp, n, reqDigest)
+		ctx = metadata.AppendToOutgoingContext(ctx, timestampKey, timestamp, nonceKey, n, signatureKey, signature)
+
+		var trailer metadata.MD
+		if err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...); err != nil {
+			return err
+		}
+
+		serviceID := first(trailer, serviceIDKey)
+		if serviceID != cc.Target() {
+			return fmt.Errorf("service %s answered as %q", cc.Target(), serviceID)
+		}
+		replyDigest, err := digest(reply)
+		if err != nil {
+			return err
+		}
+		if !h.valid(first(trailer, serviceSignatureKey), "response", serviceID, signature, replyDigest) {
+			return fmt.Errorf("invalid response signature of service %s", cc.Target())
+		}
+
+		return nil
+	}
+}
+
+// UnaryServerInterceptor rejects unsigned, stale, replayed or addressed to another service requests to methods
+// with the given prefix and signs responses with the service identity. Other methods are not authenticated.
+func (h *HMAC) UnaryServerInterceptor(serviceID, methodPrefix string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, methodPrefix) {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		timestamp, n, signature := first(md, timestampKey), first(md, nonceKey), first(md, signatureKey)
+
+		nanos, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid request timestamp")
+		}
+		sent := time.Unix(0, nanos)
+		if skew := time.Since(sent); skew > h.maxSkew || skew < -h.maxSkew {
+			return nil, status.Error(codes.Unauthenticated, "request timestamp is out of the allowed window")
+		}
+		reqDigest, err := digest(req)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		// the target is the own identity, so a request signed for another service is rejected
+		if !h.valid(signature, "request", info.FullMethod, serviceID, timestamp, n, reqDigest) {
+			return nil, status.Error(codes.Unauthenticated, "invalid request signature")
+		}
+		if !h.firstUse(signature, sent) {
+			return nil, status.Error(codes.Unauthenticated, "replayed request")
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		respDigest, err := digest(resp)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err = grpc.SetTrailer(ctx, metadata.Pairs(
+			serviceIDKey, serviceID,
+			serviceSignatureKey, h.sign("response", serviceID, signature, respDigest),
+		)); err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) != 0 {
+		return values[0]
+	}
+
+	return ""
+}
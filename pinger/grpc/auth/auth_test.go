@@ -0,0 +1,124 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auth
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/scientificideas/distributor/pinger/grpc/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type pingServer struct {
+	pb.UnimplementedGRPCPingerServer
+}
+
+func (p *pingServer) Ping(context.Context, *pb.PingRequest) (*pb.PingResponse, error) {
+	return new(pb.PingResponse), nil
+}
+
+// startServer starts ping server authenticating requests with secret and answering as serviceID (own address if empty).
+// The outer interceptors run before the authentication.
+func startServer(t *testing.T, secret, serviceID string, outer ...grpc.UnaryServerInterceptor) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if serviceID == "" {
+		serviceID = lis.Addr().String()
+	}
+
+	h, err := NewHMAC([]byte(secret), 0)
+	require.NoError(t, err)
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(append(outer, h.UnaryServerInterceptor(serviceID, "/proto.GRPCPinger/"))...))
+	pb.RegisterGRPCPingerServer(s, &pingServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+// ping sends the ping request signed with secret, the inner interceptors run after the signing.
+func ping(t *testing.T, target, secret string, inner ...grpc.UnaryClientInterceptor) error {
+	h, err := NewHMAC([]byte(secret), 0)
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial(target, grpc.WithInsecure(), grpc.WithChainUnaryInterceptor(append([]grpc.UnaryClientInterceptor{h.UnaryClientInterceptor()}, inner...)...))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = pb.NewGRPCPingerClient(conn).Ping(ctx, &pb.PingRequest{Leases: []*pb.Lease{{Unit: "unit", Token: 1}}})
+
+	return err
+}
+
+func TestHMAC(t *testing.T) {
+	assert.NoError(t, ping(t, startServer(t, "secret", ""), "secret"))
+	assert.Error(t, ping(t, startServer(t, "secret", ""), "another secret"), "request with wrong secret accepted")
+	assert.Error(t, ping(t, startServer(t, "secret", "another-service:8080"), "secret"), "impersonating service accepted")
+	assert.Error(t, ping(t, startServer(t, "another secret", ""), "secret"), "service with wrong secret accepted")
+}
+
+func TestHMACReplay(t *testing.T) {
+	target := startServer(t, "secret", "")
+	h, err := NewHMAC([]byte("secret"), 0)
+	require.NoError(t, err)
+
+	// the signed request metadata is captured after the client interceptor
+	var captured metadata.MD
+	capture := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		captured, _ = metadata.FromOutgoingContext(ctx)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	conn, err := grpc.Dial(target, grpc.WithInsecure(), grpc.WithChainUnaryInterceptor(h.UnaryClientInterceptor(), capture))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = pb.NewGRPCPingerClient(conn).Ping(ctx, &pb.PingRequest{})
+	require.NoError(t, err)
+
+	replayConn, err := grpc.Dial(target, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer replayConn.Close()
+	_, err = pb.NewGRPCPingerClient(replayConn).Ping(metadata.NewOutgoingContext(ctx, captured), &pb.PingRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "replayed request accepted")
+
+	// the request is signed for the dialed service only
+	otherConn, err := grpc.Dial(startServer(t, "secret", ""), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer otherConn.Close()
+	_, err = pb.NewGRPCPingerClient(otherConn).Ping(metadata.NewOutgoingContext(ctx, captured), &pb.PingRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "request replayed to another service accepted")
+}
+
+func TestHMACTampering(t *testing.T) {
+	tamperRequest := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		req.(*pb.PingRequest).Leases[0].Token = 2
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	err := ping(t, startServer(t, "secret", ""), "secret", tamperRequest)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "modified request accepted")
+
+	tamperResponse := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			resp.(*pb.PingResponse).Load = 100
+		}
+		return resp, err
+	}
+	assert.Error(t, ping(t, startServer(t, "secret", "", tamperResponse), "secret"), "modified response accepted")
+}
@@ -7,16 +7,18 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"google.golang.org/grpc/keepalive"
 	"time"
 
-	"github.com/golang/protobuf/ptypes/empty"
 	pb "github.com/scientificideas/distributor/pinger/grpc/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-type PingCall func(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*empty.Empty, error)
+type PingCall func(ctx context.Context, in *pb.PingRequest, opts ...grpc.CallOption) (*pb.PingResponse, error)
 
 type GRPCClient struct {
 	// cc     grpc.ClientConnInterface
@@ -54,26 +56,44 @@ func PingerClient(target string, opts ...grpc.DialOption) (*GRPCClient, error) {
 // 	return conn, err
 // }
 
-func (c *GRPCClient) Ping(ctx context.Context, _ *empty.Empty, opts ...grpc.CallOption) (*empty.Empty, error) {
+func (c *GRPCClient) Ping(ctx context.Context, in *pb.PingRequest, opts ...grpc.CallOption) (*pb.PingResponse, error) {
 	n := 15
 
-	_, err := c.client.Ping(ctx, &empty.Empty{}, opts...)
+	resp, err := c.client.Ping(ctx, in, opts...)
 	for err != nil && n > 0 {
 		time.Sleep(100 * time.Millisecond)
 		n--
 
-		_, err = c.client.Ping(ctx, &empty.Empty{})
+		resp, err = c.client.Ping(ctx, in)
 	}
 
-	return nil, err
+	return resp, err
 }
 
 func (c *GRPCClient) Close() error {
 	return c.conn.Close()
 }
 
-func NewClient(url string, keepaliveParams keepalive.ClientParameters) (*GRPCClient, error) {
-	connectParams := grpc.ConnectParams{
+// NewClient creates GRPCPinger client. The connection is insecure if creds is nil.
+// opts are applied after the default dial options.
+func NewClient(url string, keepaliveParams keepalive.ClientParameters, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*GRPCClient, error) {
+	return PingerClient(url, append([]grpc.DialOption{
+		transportSecurity(creds),
+		grpc.WithConnectParams(connectParams()),
+		grpc.WithKeepaliveParams(keepaliveParams),
+	}, opts...)...)
+}
+
+func transportSecurity(creds credentials.TransportCredentials) grpc.DialOption {
+	if creds == nil {
+		return grpc.WithInsecure()
+	}
+
+	return grpc.WithTransportCredentials(creds)
+}
+
+func connectParams() grpc.ConnectParams {
+	return grpc.ConnectParams{
 		MinConnectTimeout: 1 * time.Second,
 		Backoff: backoff.Config{
 			BaseDelay:  5 * time.Millisecond,
@@ -82,6 +102,45 @@ func NewClient(url string, keepaliveParams keepalive.ClientParameters) (*GRPCCli
 			MaxDelay:   1000 * time.Millisecond,
 		},
 	}
+}
+
+// HealthClient checks service liveness with the standard gRPC Health Checking Protocol.
+type HealthClient struct {
+	client healthpb.HealthClient
+	conn   *grpc.ClientConn
+	URL    string
+}
+
+// Check calls grpc.health.v1.Health/Check for the service name and fails if the service is not serving.
+// Empty service name requests the overall health of the server.
+func (c *HealthClient) Check(ctx context.Context, service string, opts ...grpc.CallOption) error {
+	resp, err := c.client.Check(ctx, &healthpb.HealthCheckRequest{Service: service}, opts...)
+	if err != nil {
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service %q health status is %s", service, resp.GetStatus())
+	}
+
+	return nil
+}
+
+func (c *HealthClient) Close() error {
+	return c.conn.Close()
+}
+
+// NewHealthClient creates standard gRPC health service client. The connection is insecure if creds is nil.
+// opts are applied after the default dial options.
+func NewHealthClient(url string, keepaliveParams keepalive.ClientParameters, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*HealthClient, error) {
+	conn, err := grpc.Dial(url, append([]grpc.DialOption{
+		transportSecurity(creds),
+		grpc.WithConnectParams(connectParams()),
+		grpc.WithKeepaliveParams(keepaliveParams),
+	}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
 
-	return PingerClient(url, grpc.WithInsecure(), grpc.WithConnectParams(connectParams), grpc.WithKeepaliveParams(keepaliveParams))
+	return &HealthClient{client: healthpb.NewHealthClient(conn), conn: conn, URL: url}, nil
 }
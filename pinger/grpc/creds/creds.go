@@ -0,0 +1,313 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package creds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+const defaultReloadInterval = time.Minute // default interval of certificate files modification checks
+
+// Config describes TLS settings of the ping client or the ping server.
+type Config struct {
+	RootCACerts        []string      // CA certificates files used to verify the peer; on the server they enable mTLS
+	CertFile           string        // own certificate file, required on the server and optional (mTLS) on the client
+	KeyFile            string        // own private key file
+	ServerNameOverride string        // overrides the server name verified by the client
+	ReloadInterval     time.Duration // how often certificate and CA files are checked for modifications
+}
+
+// ClientCredentials creates transport credentials for the pinger client.
+// The client certificate is sent only if CertFile and KeyFile are set.
+// The server certificate is verified against the dialed address or ServerNameOverride, including IP SANs.
+func ClientCredentials(cfg Config) (credentials.TransportCredentials, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerNameOverride,
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		kp, err := newKeyPair(cfg)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return kp.certificate()
+		}
+	}
+
+	if len(cfg.RootCACerts) == 0 {
+		return credentials.NewTLS(tlsConfig), nil
+	}
+
+	cas, err := newCAPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientCredentials{TransportCredentials: credentials.NewTLS(tlsConfig), config: tlsConfig, cas: cas}, nil
+}
+
+// clientCredentials performs each client handshake with the current CA pool, so the standard
+// verification of the server certificate and name runs against reloaded CA bundles.
+type clientCredentials struct {
+	credentials.TransportCredentials
+	config *tls.Config
+	cas    *caPool
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	pool, err := c.cas.certPool()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := c.config.Clone()
+	tlsConfig.RootCAs = pool
+
+	return credentials.NewTLS(tlsConfig).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	return &clientCredentials{TransportCredentials: c.TransportCredentials.Clone(), config: c.config.Clone(), cas: c.cas}
+}
+
+func (c *clientCredentials) OverrideServerName(name string) error {
+	c.config.ServerName = name
+	return c.TransportCredentials.OverrideServerName(name)
+}
+
+// ServerCredentials creates transport credentials for the ping server.
+// If RootCACerts are set, the server requires and verifies client certificates (mTLS).
+func ServerCredentials(cfg Config) (credentials.TransportCredentials, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("server certificate and key files are required for TLS")
+	}
+
+	kp, err := newKeyPair(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return kp.certificate()
+		},
+	}
+
+	if len(cfg.RootCACerts) != 0 {
+		cas, err := newCAPool(cfg)
+		if err != nil {
+			return nil, err
+		}
+		// ClientCAs can't be replaced in a shared config, so client certificates are verified
+		// against the current pool after the handshake instead of the static one
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			pool, err := cas.certPool()
+			if err != nil {
+				return err
+			}
+			return verifyPeer(cs, pool, x509.ExtKeyUsageClientAuth)
+		}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// verifyPeer verifies the peer certificate chain against the CA pool.
+func verifyPeer(cs tls.ConnectionState, pool *x509.CertPool, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer sent no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+
+	return err
+}
+
+func certPool(rootCAs []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, rootCA := range rootCAs {
+		cert, err := ioutil.ReadFile(rootCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root CA certificate %s", rootCA)
+		}
+
+		if ok := pool.AppendCertsFromPEM(cert); !ok {
+			return nil, fmt.Errorf("failed to add root CA certificate %s to the certificate pool", rootCA)
+		}
+	}
+
+	return pool, nil
+}
+
+// watchedFiles tracks modifications of files loaded together.
+type watchedFiles struct {
+	files    []string
+	interval time.Duration
+	modTime  time.Time // latest modification time of the loaded files
+	checked  time.Time // last time the files were checked for modifications
+}
+
+func newWatchedFiles(interval time.Duration, files ...string) watchedFiles {
+	if interval == 0 {
+		interval = defaultReloadInterval
+	}
+
+	return watchedFiles{files: files, interval: interval}
+}
+
+// modified returns the latest modification time of the files and whether they must be loaded:
+// they are not loaded yet or they were modified since the last load. Files are checked once per interval.
+func (w *watchedFiles) modified(loaded bool) (time.Time, bool, error) {
+	now := time.Now()
+	if loaded && now.Sub(w.checked) < w.interval {
+		return time.Time{}, false, nil
+	}
+	w.checked = now
+
+	modTime, err := latestModTime(w.files...)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return modTime, !loaded || modTime.After(w.modTime), nil
+}
+
+// keyPair holds certificate and key loaded from files and reloads them when the files are modified,
+// so certificates can be rotated without restart.
+type keyPair struct {
+	mu    sync.Mutex
+	files watchedFiles
+	cert  *tls.Certificate
+}
+
+func newKeyPair(cfg Config) (*keyPair, error) {
+	kp := &keyPair{files: newWatchedFiles(cfg.ReloadInterval, cfg.CertFile, cfg.KeyFile)}
+	if _, err := kp.certificate(); err != nil {
+		return nil, err
+	}
+
+	return kp, nil
+}
+
+// certificate returns the current certificate, reloading it if the files were modified since the last load.
+// If reloading fails, the previously loaded certificate is kept.
+func (kp *keyPair) certificate() (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	modTime, modified, err := kp.files.modified(kp.cert != nil)
+	if err != nil {
+		if kp.cert != nil {
+			return kp.cert, nil
+		}
+		return nil, err
+	}
+	if !modified {
+		return kp.cert, nil
+	}
+
+	certFile, keyFile := kp.files.files[0], kp.files.files[1]
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		if kp.cert != nil {
+			return kp.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate %s and key %s, %w", certFile, keyFile, err)
+	}
+
+	kp.cert = &cert
+	kp.files.modTime = modTime
+
+	return kp.cert, nil
+}
+
+// caPool holds the pool of CA certificates loaded from files and reloads it when the files are modified,
+// so CA bundles can be rotated without restart.
+type caPool struct {
+	mu    sync.Mutex
+	files watchedFiles
+	pool  *x509.CertPool
+}
+
+func newCAPool(cfg Config) (*caPool, error) {
+	cas := &caPool{files: newWatchedFiles(cfg.ReloadInterval, cfg.RootCACerts...)}
+	if _, err := cas.certPool(); err != nil {
+		return nil, err
+	}
+
+	return cas, nil
+}
+
+// certPool returns the current pool, reloading it if the files were modified since the last load.
+// If reloading fails, the previously loaded pool is kept.
+func (cas *caPool) certPool() (*x509.CertPool, error) {
+	cas.mu.Lock()
+	defer cas.mu.Unlock()
+
+	modTime, modified, err := cas.files.modified(cas.pool != nil)
+	if err != nil {
+		if cas.pool != nil {
+			return cas.pool, nil
+		}
+		return nil, err
+	}
+	if !modified {
+		return cas.pool, nil
+	}
+
+	pool, err := certPool(cas.files.files)
+	if err != nil {
+		if cas.pool != nil {
+			return cas.pool, nil
+		}
+		return nil, err
+	}
+
+	cas.pool = pool
+	cas.files.modTime = modTime
+
+	return cas.pool, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
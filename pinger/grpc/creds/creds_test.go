@@ -0,0 +1,225 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package creds
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+const testReloadInterval = 10 * time.Millisecond
+
+var modTime time.Time // modification time of the last written files
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newCert creates the certificate signed by parent, self-signed CA certificate if parent is nil.
+func newCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes PEM files of certificates, the key of the first one is written if keyFile is set.
+// Modification times always grow, so the files are reloaded regardless of the file system time resolution.
+func write(t *testing.T, certFile, keyFile string, certs ...*testCert) {
+	var data []byte
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})...)
+	}
+	require.NoError(t, ioutil.WriteFile(certFile, data, 0o600))
+	files := []string{certFile}
+	if keyFile != "" {
+		key, err := x509.MarshalECPrivateKey(certs[0].key)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0o600))
+		files = append(files, keyFile)
+	}
+
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	modTime = modTime.Add(time.Second)
+	for _, file := range files {
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+	}
+	time.Sleep(2 * testReloadInterval)
+}
+
+// handshake performs TLS handshake of the client and the server over the loopback connection.
+func handshake(t *testing.T, client, server credentials.TransportCredentials) error {
+	return handshakeTo(t, client, server, "localhost:443")
+}
+
+// handshakeTo performs the handshake as if the client dialed the authority.
+func handshakeTo(t *testing.T, client, server credentials.TransportCredentials, authority string) error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		_, _, err = server.ServerHandshake(conn)
+		serverErr <- err
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	secure, _, err := client.ClientHandshake(ctx, authority, conn)
+	if err == nil {
+		defer secure.Close()
+	} else {
+		conn.Close()
+	}
+	if sErr := <-serverErr; err == nil {
+		err = sErr
+	}
+
+	return err
+}
+
+func TestKeyPairReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newCert(t, "ca", nil, x509.ExtKeyUsageAny)
+	first, second := newCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth), newCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+
+	write(t, certFile, keyFile, first)
+	kp, err := newKeyPair(Config{CertFile: certFile, KeyFile: keyFile, ReloadInterval: testReloadInterval})
+	require.NoError(t, err)
+	cert, err := kp.certificate()
+	require.NoError(t, err)
+	assert.Equal(t, first.der, cert.Certificate[0])
+
+	// the rotated key pair is loaded
+	write(t, certFile, keyFile, second)
+	cert, err = kp.certificate()
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0])
+
+	// a broken key pair doesn't replace the loaded one
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("broken"), 0o600))
+	write(t, certFile, "", second)
+	cert, err = kp.certificate()
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0])
+}
+
+func TestCAReload(t *testing.T) {
+	dir := t.TempDir()
+	oldCA, newCA := newCert(t, "old-ca", nil, x509.ExtKeyUsageAny), newCert(t, "new-ca", nil, x509.ExtKeyUsageAny)
+	serverCert, serverKey, serverCAs := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), filepath.Join(dir, "server-ca.pem")
+	clientCert, clientKey, clientCAs := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"), filepath.Join(dir, "client-ca.pem")
+	write(t, serverCert, serverKey, newCert(t, "localhost", newCA, x509.ExtKeyUsageServerAuth))
+	write(t, clientCert, clientKey, newCert(t, "distributor", oldCA, x509.ExtKeyUsageClientAuth))
+	write(t, serverCAs, "", oldCA)
+	write(t, clientCAs, "", oldCA)
+
+	server, err := ServerCredentials(Config{CertFile: serverCert, KeyFile: serverKey, RootCACerts: []string{serverCAs}, ReloadInterval: testReloadInterval})
+	require.NoError(t, err)
+	client, err := ClientCredentials(Config{CertFile: clientCert, KeyFile: clientKey, RootCACerts: []string{clientCAs}, ReloadInterval: testReloadInterval})
+	require.NoError(t, err)
+
+	// the server certificate is signed by the CA the client doesn't trust yet
+	assert.Error(t, handshake(t, client, server))
+	write(t, clientCAs, "", oldCA, newCA)
+	assert.NoError(t, handshake(t, client, server))
+
+	// the server stops trusting the CA of the client certificate
+	write(t, serverCAs, "", newCA)
+	assert.Error(t, handshake(t, client, server))
+
+	// the server name is verified
+	other, err := ClientCredentials(Config{
+		CertFile: clientCert, KeyFile: clientKey, RootCACerts: []string{clientCAs}, ServerNameOverride: "other", ReloadInterval: testReloadInterval,
+	})
+	require.NoError(t, err)
+	write(t, serverCAs, "", oldCA)
+	assert.NoError(t, handshake(t, client, server))
+	assert.Error(t, handshake(t, other, server))
+}
+
+func TestServerAddressVerified(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil, x509.ExtKeyUsageAny)
+	otherCert, otherKey := filepath.Join(dir, "other.pem"), filepath.Join(dir, "other-key.pem")
+	ipCert, ipKey := filepath.Join(dir, "ip.pem"), filepath.Join(dir, "ip-key.pem")
+	cas := filepath.Join(dir, "ca.pem")
+	write(t, otherCert, otherKey, newCert(t, "some-other-service", ca, x509.ExtKeyUsageServerAuth))
+	write(t, ipCert, ipKey, newCert(t, "10.1.2.3", ca, x509.ExtKeyUsageServerAuth))
+	write(t, cas, "", ca)
+
+	client, err := ClientCredentials(Config{RootCACerts: []string{cas}, ReloadInterval: testReloadInterval})
+	require.NoError(t, err)
+	other, err := ServerCredentials(Config{CertFile: otherCert, KeyFile: otherKey})
+	require.NoError(t, err)
+	ip, err := ServerCredentials(Config{CertFile: ipCert, KeyFile: ipKey})
+	require.NoError(t, err)
+
+	// a certificate issued by the trusted CA for another name doesn't match the IP address
+	assert.Error(t, handshakeTo(t, client, other, "10.1.2.3:443"))
+	// the IP address is verified against IP SANs
+	assert.NoError(t, handshakeTo(t, client, ip, "10.1.2.3:443"))
+	assert.Error(t, handshakeTo(t, client, ip, "10.1.2.4:443"))
+
+	// the override is verified instead of the address
+	override, err := ClientCredentials(Config{RootCACerts: []string{cas}, ServerNameOverride: "some-other-service", ReloadInterval: testReloadInterval})
+	require.NoError(t, err)
+	assert.NoError(t, handshakeTo(t, override, other, "10.1.2.3:443"))
+}
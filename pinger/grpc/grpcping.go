@@ -11,9 +11,14 @@ import (
 	"google.golang.org/grpc/keepalive"
 	"sync"
 
-	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/proto"
+	"github.com/scientificideas/distributor/pinger"
+	"github.com/scientificideas/distributor/pinger/grpc/auth"
 	"github.com/scientificideas/distributor/pinger/grpc/client"
+	pb "github.com/scientificideas/distributor/pinger/grpc/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Pinger is a gRPC implementation of Pinger interface that checks services liveness.
@@ -21,6 +26,40 @@ type Pinger struct {
 	mu           sync.RWMutex
 	connPool     map[string]*client.GRPCClient
 	kaParameters keepalive.ClientParameters
+	creds        credentials.TransportCredentials
+	dialOpts     []grpc.DialOption
+}
+
+// Option configures gRPC pingers.
+type Option func(o *options)
+
+type options struct {
+	creds credentials.TransportCredentials
+	auth  *auth.HMAC
+}
+
+// WithTransportCredentials makes pinger connect to services over TLS, connections are insecure by default.
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) {
+		o.creds = creds
+	}
+}
+
+// WithHMACAuth makes Pinger sign ping requests and verify that the answering service is the registered one.
+// The services must be started with the same secret. HealthPinger requests are not authenticated.
+func WithHMACAuth(h *auth.HMAC) Option {
+	return func(o *options) {
+		o.auth = h
+	}
+}
+
+func applyOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
 }
 
 func (p *Pinger) connAlreadyExistsInPool(url string) bool {
@@ -50,15 +89,26 @@ func (p *Pinger) addConnToPool(url string, conn *client.GRPCClient) {
 }
 
 // NewPinger creates GRPCPinger instance.
-func NewPinger(kaparameters keepalive.ClientParameters) *Pinger {
-	return &Pinger{connPool: make(map[string]*client.GRPCClient)}
+func NewPinger(kaparameters keepalive.ClientParameters, opts ...Option) *Pinger {
+	o := applyOptions(opts)
+
+	p := &Pinger{
+		connPool:     make(map[string]*client.GRPCClient),
+		kaParameters: kaparameters,
+		creds:        o.creds,
+	}
+	if o.auth != nil {
+		p.dialOpts = append(p.dialOpts, grpc.WithUnaryInterceptor(o.auth.UnaryClientInterceptor()))
+	}
+
+	return p
 }
 
 // Init creates connections to the all services and adds them to local pool.
 func (p *Pinger) Init(urls ...string) error {
 	for _, url := range urls {
 		if !p.connAlreadyExistsInPool(url) {
-			pingerClient, err := client.NewClient(url, p.kaParameters)
+			pingerClient, err := client.NewClient(url, p.kaParameters, p.creds, p.dialOpts...)
 			if err != nil {
 				return fmt.Errorf("failed to connect to client %s, %w", url, err)
 			}
@@ -72,20 +122,58 @@ func (p *Pinger) Init(urls ...string) error {
 
 // Ping makes a gRPC call to the service to check it's liveness.
 func (p *Pinger) Ping(ctx context.Context, url string) error {
+	_, err := p.ping(ctx, url)
+
+	return err
+}
+
+// PingStatus makes a gRPC call to the service to check it's liveness and returns the status reported by the service.
+func (p *Pinger) PingStatus(ctx context.Context, url string) (*pinger.Status, error) {
+	resp, err := p.ping(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	// services without status provider answer with empty response
+	if !resp.GetHasStatus() && proto.Size(resp) == 0 {
+		return nil, nil
+	}
+
+	return &pinger.Status{
+		ServiceID: resp.GetServiceId(),
+		Version:   resp.GetVersion(),
+		Units:     resp.GetUnits(),
+		Load:      resp.GetLoad(),
+		Capacity:  resp.GetCapacity(),
+		Ready:     resp.GetReady(),
+		Draining:  resp.GetDraining(),
+		UnitLoads: resp.GetUnitLoads(),
+	}, nil
+}
+
+// ping sends to the service leases from the context.
+func (p *Pinger) ping(ctx context.Context, url string) (*pb.PingResponse, error) {
+	req := new(pb.PingRequest)
+	for _, lease := range pinger.LeasesFromContext(ctx) {
+		req.Leases = append(req.Leases, &pb.Lease{
+			Unit:   lease.Unit,
+			Token:  lease.Token,
+			Expiry: timestamppb.New(lease.Expiry),
+		})
+	}
+
 	if p.connAlreadyExistsInPool(url) {
 		c := p.getConn(url)
-		_, err := c.Ping(ctx, &empty.Empty{}, grpc.WaitForReady(true))
 
-		return err
+		return c.Ping(ctx, req, grpc.WaitForReady(true))
 	}
 
-	c, err := client.NewClient(url, p.kaParameters)
+	c, err := client.NewClient(url, p.kaParameters, p.creds, p.dialOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to connect to client %s, %w", url, err)
+		return nil, fmt.Errorf("failed to connect to client %s, %w", url, err)
 	}
 
 	p.addConnToPool(url, c)
-	_, err = c.Ping(ctx, &empty.Empty{}, grpc.WaitForReady(true))
 
-	return err
+	return c.Ping(ctx, req, grpc.WaitForReady(true))
 }
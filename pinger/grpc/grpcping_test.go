@@ -0,0 +1,88 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/scientificideas/distributor/pinger"
+	"github.com/scientificideas/distributor/pinger/grpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve starts the ping server on the in-memory listener and returns the option dialing it.
+func serve(t *testing.T, opts ...server.Option) grpc.DialOption {
+	lis := bufconn.Listen(1 << 20)
+	s, err := server.NewServer(opts...)
+	require.NoError(t, err)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	})
+}
+
+func pingStatus(t *testing.T, p *Pinger) (*pinger.Status, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return p.PingStatus(ctx, "bufnet")
+}
+
+func TestPingStatus(t *testing.T) {
+	// services without status provider are legacy ones
+	p := NewPinger(keepalive.ClientParameters{})
+	p.dialOpts = append(p.dialOpts, serve(t))
+	status, err := pingStatus(t, p)
+	require.NoError(t, err)
+	assert.Nil(t, status)
+
+	// not ready status with all other fields zero is reported
+	p = NewPinger(keepalive.ClientParameters{})
+	p.dialOpts = append(p.dialOpts, serve(t, server.WithStatusProvider(func(context.Context) pinger.Status {
+		return pinger.Status{}
+	})))
+	status, err = pingStatus(t, p)
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.False(t, status.Ready)
+}
+
+func TestPingLeases(t *testing.T) {
+	received := make(chan []pinger.Lease, 1)
+	p := NewPinger(keepalive.ClientParameters{})
+	p.dialOpts = append(p.dialOpts, serve(t, server.WithLeaseHandler(func(_ context.Context, leases []pinger.Lease) {
+		received <- leases
+	})))
+
+	expiry := time.Now().Add(time.Minute)
+	leases := []pinger.Lease{{Unit: "work1", Token: 3, Expiry: expiry}, {Unit: "work2", Token: 5, Expiry: expiry}}
+	ctx, cancel := context.WithTimeout(pinger.WithLeases(context.Background(), leases), time.Second)
+	defer cancel()
+	_, err := p.PingStatus(ctx, "bufnet")
+	require.NoError(t, err)
+
+	got := <-received
+	require.Len(t, got, len(leases))
+	for i, lease := range leases {
+		assert.Equal(t, lease.Unit, got[i].Unit)
+		assert.Equal(t, lease.Token, got[i].Token)
+		assert.True(t, lease.Expiry.Equal(got[i].Expiry))
+	}
+
+	// pings without leases pass no leases to the handler
+	_, err = pingStatus(t, p)
+	require.NoError(t, err)
+	assert.Empty(t, <-received)
+}
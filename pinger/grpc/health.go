@@ -0,0 +1,86 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/scientificideas/distributor/pinger/grpc/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// HealthPinger is a Pinger implementation that checks services liveness with the standard
+// gRPC Health Checking Protocol (grpc.health.v1.Health/Check).
+type HealthPinger struct {
+	mu           sync.RWMutex
+	connPool     map[string]*client.HealthClient
+	kaParameters keepalive.ClientParameters
+	service      string
+	creds        credentials.TransportCredentials
+	dialOpts     []grpc.DialOption
+}
+
+// NewHealthPinger creates HealthPinger instance.
+// service is the name of the checked gRPC service, empty name checks the overall server health.
+func NewHealthPinger(kaparameters keepalive.ClientParameters, service string, opts ...Option) *HealthPinger {
+	o := applyOptions(opts)
+
+	return &HealthPinger{
+		connPool:     make(map[string]*client.HealthClient),
+		kaParameters: kaparameters,
+		service:      service,
+		creds:        o.creds,
+	}
+}
+
+func (p *HealthPinger) getConn(url string) (*client.HealthClient, bool) {
+	p.mu.RLock()
+	c, ok := p.connPool[url]
+	p.mu.RUnlock()
+
+	return c, ok
+}
+
+func (p *HealthPinger) addConnToPool(url string, conn *client.HealthClient) {
+	p.mu.Lock()
+	p.connPool[url] = conn
+	p.mu.Unlock()
+}
+
+// Init creates connections to the all services and adds them to local pool.
+func (p *HealthPinger) Init(urls ...string) error {
+	for _, url := range urls {
+		if _, ok := p.getConn(url); !ok {
+			healthClient, err := client.NewHealthClient(url, p.kaParameters, p.creds, p.dialOpts...)
+			if err != nil {
+				return fmt.Errorf("failed to connect to client %s, %w", url, err)
+			}
+
+			p.addConnToPool(url, healthClient)
+		}
+	}
+
+	return nil
+}
+
+// Ping makes a health check call to the service to check it's liveness.
+func (p *HealthPinger) Ping(ctx context.Context, url string) error {
+	c, ok := p.getConn(url)
+	if !ok {
+		var err error
+		if c, err = client.NewHealthClient(url, p.kaParameters, p.creds, p.dialOpts...); err != nil {
+			return fmt.Errorf("failed to connect to client %s, %w", url, err)
+		}
+
+		p.addConnToPool(url, c)
+	}
+
+	return c.Check(ctx, p.service, grpc.WaitForReady(true))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serveHealth starts the gRPC server on the in-memory listener, with the health service if it's set,
+// and returns the health pinger of the service dialing it.
+func serveHealth(t *testing.T, healthServer *health.Server, service string) *HealthPinger {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	if healthServer != nil {
+		healthpb.RegisterHealthServer(s, healthServer)
+	}
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	p := NewHealthPinger(keepalive.ClientParameters{}, service)
+	p.dialOpts = append(p.dialOpts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+
+	return p
+}
+
+func healthPing(p *HealthPinger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return p.Ping(ctx, "bufnet")
+}
+
+func TestHealthPinger(t *testing.T) {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("robots", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("parsers", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	// the empty service name checks the overall server health
+	assert.NoError(t, healthPing(serveHealth(t, healthServer, "")))
+	assert.NoError(t, healthPing(serveHealth(t, healthServer, "robots")))
+	assert.Error(t, healthPing(serveHealth(t, healthServer, "parsers")))
+	assert.Equal(t, codes.NotFound, status.Code(healthPing(serveHealth(t, healthServer, "unknown"))))
+
+	// the status change is seen by the next ping
+	p := serveHealth(t, healthServer, "parsers")
+	assert.NoError(t, p.Init("bufnet"))
+	healthServer.SetServingStatus("parsers", healthpb.HealthCheckResponse_SERVING)
+	assert.NoError(t, healthPing(p))
+
+	// services without the health service fail
+	assert.Equal(t, codes.Unimplemented, status.Code(healthPing(serveHealth(t, nil, ""))))
+}
@@ -4,7 +4,6 @@ package pinger
 
 import (
 	context "context"
-	empty "github.com/golang/protobuf/ptypes/empty"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -19,7 +18,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type GRPCPingerClient interface {
 	// check service liveness
-	Ping(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*empty.Empty, error)
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
 }
 
 type gRPCPingerClient struct {
@@ -30,8 +29,8 @@ func NewGRPCPingerClient(cc grpc.ClientConnInterface) GRPCPingerClient {
 	return &gRPCPingerClient{cc}
 }
 
-func (c *gRPCPingerClient) Ping(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*empty.Empty, error) {
-	out := new(empty.Empty)
+func (c *gRPCPingerClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
+	out := new(PingResponse)
 	err := c.cc.Invoke(ctx, "/proto.GRPCPinger/Ping", in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -44,7 +43,7 @@ func (c *gRPCPingerClient) Ping(ctx context.Context, in *empty.Empty, opts ...gr
 // for forward compatibility
 type GRPCPingerServer interface {
 	// check service liveness
-	Ping(context.Context, *empty.Empty) (*empty.Empty, error)
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
 	mustEmbedUnimplementedGRPCPingerServer()
 }
 
@@ -52,7 +51,7 @@ type GRPCPingerServer interface {
 type UnimplementedGRPCPingerServer struct {
 }
 
-func (UnimplementedGRPCPingerServer) Ping(context.Context, *empty.Empty) (*empty.Empty, error) {
+func (UnimplementedGRPCPingerServer) Ping(context.Context, *PingRequest) (*PingResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
 }
 func (UnimplementedGRPCPingerServer) mustEmbedUnimplementedGRPCPingerServer() {}
@@ -69,7 +68,7 @@ func RegisterGRPCPingerServer(s grpc.ServiceRegistrar, srv GRPCPingerServer) {
 }
 
 func _GRPCPinger_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(empty.Empty)
+	in := new(PingRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -81,7 +80,7 @@ func _GRPCPinger_Ping_Handler(srv interface{}, ctx context.Context, dec func(int
 		FullMethod: "/proto.GRPCPinger/Ping",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(GRPCPingerServer).Ping(ctx, req.(*empty.Empty))
+		return srv.(GRPCPingerServer).Ping(ctx, req.(*PingRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -0,0 +1,73 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package server
+
+import (
+	"github.com/scientificideas/distributor/pinger/grpc/auth"
+	"github.com/scientificideas/distributor/pinger/grpc/creds"
+	"google.golang.org/grpc"
+)
+
+// Option configures the ping server started by Inject.
+type Option func(o *options) error
+
+type options struct {
+	serverOptions []grpc.ServerOption
+	status        StatusProvider
+	leases        LeaseHandler
+}
+
+// WithTLS makes the ping server accept TLS connections only.
+// If cfg.RootCACerts are set, clients must present certificates signed by these CAs (mTLS).
+// Certificate and key files are reloaded on modification.
+func WithTLS(cfg creds.Config) Option {
+	return func(o *options) error {
+		tlsCreds, err := creds.ServerCredentials(cfg)
+		if err != nil {
+			return err
+		}
+
+		o.serverOptions = append(o.serverOptions, grpc.Creds(tlsCreds))
+
+		return nil
+	}
+}
+
+// WithHMACAuth makes the ping server accept only ping requests signed with the secret shared with the Distributor
+// and sign responses with serviceID, which must be the address the service is registered under.
+// Standard health service requests are not authenticated.
+func WithHMACAuth(secret []byte, serviceID string) Option {
+	return func(o *options) error {
+		h, err := auth.NewHMAC(secret, 0)
+		if err != nil {
+			return err
+		}
+
+		o.serverOptions = append(o.serverOptions, grpc.ChainUnaryInterceptor(h.UnaryServerInterceptor(serviceID, "/"+pingerServiceName+"/")))
+
+		return nil
+	}
+}
+
+// WithStatusProvider makes the ping server report the service status returned by provider on every ping.
+// The Distributor uses it to verify the service identity and readiness and to balance work by load.
+func WithStatusProvider(provider StatusProvider) Option {
+	return func(o *options) error {
+		o.status = provider
+
+		return nil
+	}
+}
+
+// WithLeaseHandler makes the ping server pass leases of work units assigned to the service to handler on every ping.
+// The service should attach lease tokens to its writes, so downstream systems can reject writes with stale tokens.
+func WithLeaseHandler(handler LeaseHandler) Option {
+	return func(o *options) error {
+		o.leases = handler
+
+		return nil
+	}
+}
@@ -11,31 +11,97 @@ import (
 	"net"
 	"strconv"
 
-	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/scientificideas/distributor/pinger"
 	pb "github.com/scientificideas/distributor/pinger/grpc/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// pingerServiceName is the GRPCPinger service name reported by the standard health service.
+const pingerServiceName = "proto.GRPCPinger"
+
+// StatusProvider returns the current service status reported to the Distributor in ping responses.
+type StatusProvider func(ctx context.Context) pinger.Status
+
+// LeaseHandler receives leases of work units assigned to the service sent by the Distributor in ping requests.
+type LeaseHandler func(ctx context.Context, leases []pinger.Lease)
+
 type PingServer struct {
 	pb.UnimplementedGRPCPingerServer
+	status StatusProvider
+	leases LeaseHandler
+}
+
+// Ping passes leases from the request to the lease handler if it's set and responds with the service status
+// if the status provider is set and with empty response otherwise.
+func (p *PingServer) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
+	if p.leases != nil {
+		leases := make([]pinger.Lease, 0, len(req.GetLeases()))
+		for _, lease := range req.GetLeases() {
+			leases = append(leases, pinger.Lease{
+				Unit:   lease.GetUnit(),
+				Token:  lease.GetToken(),
+				Expiry: lease.GetExpiry().AsTime(),
+			})
+		}
+		p.leases(ctx, leases)
+	}
+
+	if p.status == nil {
+		return new(pb.PingResponse), nil
+	}
+
+	status := p.status(ctx)
+
+	return &pb.PingResponse{
+		ServiceId: status.ServiceID,
+		Version:   status.Version,
+		Units:     status.Units,
+		Load:      status.Load,
+		Capacity:  status.Capacity,
+		Ready:     status.Ready,
+		Draining:  status.Draining,
+		UnitLoads: status.UnitLoads,
+		HasStatus: true,
+	}, nil
 }
 
-func (p *PingServer) Ping(context.Context, *empty.Empty) (*empty.Empty, error) {
-	return new(empty.Empty), nil
+// NewServer creates the gRPC server with GRPCPinger and the standard health service registered,
+// services which serve other gRPC services as well register them on it.
+func NewServer(opts ...Option) (*grpc.Server, error) {
+	o := new(options)
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+
+	grpcServer := grpc.NewServer(o.serverOptions...)
+	pb.RegisterGRPCPingerServer(grpcServer, &PingServer{status: o.status, leases: o.leases})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pingerServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	return grpcServer, nil
 }
 
 // Inject is a helper function for distributor managed services.
 // It starts GRPC server that responds to liveness requests.
-func Inject(onHost string, onPort uint) {
+// Besides GRPCPinger the server registers the standard gRPC health service,
+// so the service can be checked by grpc-health-probe and load balancers as well.
+func Inject(onHost string, onPort uint, opts ...Option) {
+	grpcServer, err := NewServer(opts...)
+	if err != nil {
+		log.Fatalf("failed to configure ping server: %v", err)
+	}
+
 	lis, err := net.Listen("tcp", net.JoinHostPort(onHost, strconv.Itoa(int(onPort))))
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	pingServer := &PingServer{}
-	pb.RegisterGRPCPingerServer(grpcServer, pingServer)
-
 	if err = grpcServer.Serve(lis); err != nil {
 		log.Fatalf(err.Error())
 	}
@@ -0,0 +1,138 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPath                = "/healthz"
+	defaultScheme              = "http"
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultDialTimeout         = 1 * time.Second
+	maxBodySize                = 64 << 10 // max size of the health endpoint response body read by Pinger
+)
+
+// Config configures HTTP health checks.
+type Config struct {
+	Scheme              string        // scheme used for services registered without it ("http" or "https")
+	Path                string        // health endpoint path, e.g. /healthz
+	ExpectedCodes       []int         // response status codes considered healthy, 200 if empty
+	ExpectedBody        string        // substring the response body must contain, not checked if empty
+	Timeout             time.Duration // whole request timeout, ping context deadline is used if zero
+	DialTimeout         time.Duration // TCP connect timeout
+	MaxIdleConnsPerHost int           // idle keep-alive connections kept for every service
+	IdleConnTimeout     time.Duration // how long an idle keep-alive connection stays in the pool
+}
+
+// Pinger is an HTTP implementation of Pinger interface that checks services liveness
+// by requesting their health endpoint.
+type Pinger struct {
+	client        *http.Client
+	scheme        string
+	path          string
+	expectedCodes map[int]struct{}
+	expectedBody  []byte
+}
+
+// NewPinger creates HTTP Pinger instance.
+// Connections to services are kept alive and reused between pings.
+func NewPinger(cfg Config) *Pinger {
+	if cfg.Scheme == "" {
+		cfg.Scheme = defaultScheme
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultPath
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		cfg.Path = "/" + cfg.Path
+	}
+	if len(cfg.ExpectedCodes) == 0 {
+		cfg.ExpectedCodes = []int{http.StatusOK}
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.MaxIdleConnsPerHost == 0 {
+		cfg.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout == 0 {
+		cfg.IdleConnTimeout = defaultIdleConnTimeout
+	}
+
+	codes := make(map[int]struct{}, len(cfg.ExpectedCodes))
+	for _, code := range cfg.ExpectedCodes {
+		codes[code] = struct{}{}
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		TLSHandshakeTimeout: cfg.DialTimeout,
+	}
+
+	return &Pinger{
+		client:        &http.Client{Transport: transport, Timeout: cfg.Timeout},
+		scheme:        cfg.Scheme,
+		path:          cfg.Path,
+		expectedCodes: codes,
+		expectedBody:  []byte(cfg.ExpectedBody),
+	}
+}
+
+// Init does nothing: connections are established lazily on the first ping and reused afterwards.
+func (p *Pinger) Init(_ ...string) error {
+	return nil
+}
+
+// Ping requests the health endpoint of the service and checks the response status code and body.
+func (p *Pinger) Ping(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint(url), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health request for %s, %w", url, err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the body must be read to the end for the connection to be reused
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read health response of %s, %w", url, err)
+	}
+
+	if _, ok := p.expectedCodes[resp.StatusCode]; !ok {
+		return fmt.Errorf("unexpected health response status code %d", resp.StatusCode)
+	}
+	if len(p.expectedBody) != 0 && !bytes.Contains(body, p.expectedBody) {
+		return fmt.Errorf("health response body doesn't contain %q", p.expectedBody)
+	}
+
+	return nil
+}
+
+// endpoint builds health endpoint URL of the service registered as host:port or as base URL.
+func (p *Pinger) endpoint(url string) string {
+	if !strings.Contains(url, "://") {
+		url = p.scheme + "://" + url
+	}
+
+	return strings.TrimSuffix(url, "/") + p.path
+}
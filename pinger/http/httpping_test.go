@@ -0,0 +1,68 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ping(p *Pinger, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return p.Ping(ctx, url)
+}
+
+func TestPing(t *testing.T) {
+	code, body := http.StatusOK, "ok"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/ready":
+			w.WriteHeader(code)
+			w.Write([]byte(body))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	// services are registered as host:port or as base URL
+	p := NewPinger(Config{})
+	assert.NoError(t, ping(p, address))
+	assert.NoError(t, ping(p, server.URL+"/"))
+	assert.Error(t, ping(NewPinger(Config{Scheme: "https"}), address))
+
+	// status codes
+	code = http.StatusServiceUnavailable
+	assert.Error(t, ping(p, address))
+	code = http.StatusNoContent
+	assert.Error(t, ping(p, address))
+	assert.NoError(t, ping(NewPinger(Config{Path: "ready", ExpectedCodes: []int{http.StatusOK, http.StatusNoContent}}), address))
+	assert.Error(t, ping(NewPinger(Config{Path: "/missing"}), address))
+
+	// body
+	code, body = http.StatusOK, `{"status":"ok"}`
+	assert.NoError(t, ping(NewPinger(Config{ExpectedBody: `"status":"ok"`}), address))
+	body = `{"status":"starting"}`
+	assert.Error(t, ping(NewPinger(Config{ExpectedBody: `"status":"ok"`}), address))
+
+	// timeouts
+	assert.Error(t, ping(NewPinger(Config{Path: "/slow", Timeout: 50 * time.Millisecond}), address))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, NewPinger(Config{Path: "/slow"}).Ping(ctx, address))
+	assert.NoError(t, ping(NewPinger(Config{Path: "/slow", ExpectedCodes: []int{http.StatusOK}}), address))
+}
@@ -5,7 +5,10 @@ SPDX-License-Identifier: Apache-2.0
 
 package pinger
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Pinger is responsible for checking services liveness.
 type Pinger interface {
@@ -14,3 +17,46 @@ type Pinger interface {
 	// Ping makes a ping call to the service to check it's liveness
 	Ping(ctx context.Context, url string) error
 }
+
+// Status is the service state reported in response to ping.
+type Status struct {
+	ServiceID string             // identity of the service, the address it is registered under
+	Version   string             // version of the service
+	Units     []string           // work units currently processed by the service
+	Load      float64            // current load of the service in service-defined units
+	Capacity  float64            // max load the service can handle in the same units
+	Ready     bool               // service is ready to accept work
+	Draining  bool               // service is going to stop and must not get new work
+	UnitLoads map[string]float64 // processing load of every work unit in the same units as Load
+}
+
+// StatusPinger is implemented by pingers which receive the service status in ping response.
+type StatusPinger interface {
+	Pinger
+	// PingStatus makes a ping call to the service and returns the reported status,
+	// nil status means the service is alive but doesn't report its status
+	PingStatus(ctx context.Context, url string) (*Status, error)
+}
+
+// Lease is the service ownership of a work unit until expiry. Tokens of the work unit leases increase monotonically,
+// so systems receiving writes from services can reject the ones made with a token less than the last seen.
+type Lease struct {
+	Unit   string    // work unit
+	Token  int64     // fencing token
+	Expiry time.Time // time until the service owns the work unit unless the lease is renewed
+}
+
+type leasesKey struct{}
+
+// WithLeases returns the context carrying leases of work units assigned to the pinged service.
+// Pingers which support it send them to the service.
+func WithLeases(ctx context.Context, leases []Lease) context.Context {
+	return context.WithValue(ctx, leasesKey{}, leases)
+}
+
+// LeasesFromContext returns leases set with WithLeases.
+func LeasesFromContext(ctx context.Context) []Lease {
+	leases, _ := ctx.Value(leasesKey{}).([]Lease)
+
+	return leases
+}
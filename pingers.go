@@ -0,0 +1,184 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scientificideas/distributor/config"
+	"github.com/scientificideas/distributor/pinger"
+	grpcping "github.com/scientificideas/distributor/pinger/grpc"
+	"github.com/scientificideas/distributor/pinger/grpc/auth"
+	"github.com/scientificideas/distributor/pinger/grpc/creds"
+	httpping "github.com/scientificideas/distributor/pinger/http"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	grpcPinger       = "grpc"
+	grpcHealthPinger = "grpc-health"
+	httpPinger       = "http"
+)
+
+// pingers creates Pinger instances for distribution groups and shares them between groups of the same pinger type.
+type pingers struct {
+	conf        *config.Config
+	pingTimeout time.Duration
+	kinds       map[string]string // services namespace -> pinger type
+	instances   map[string]pinger.Pinger
+}
+
+func newPingers(conf *config.Config, pingTimeout time.Duration) (*pingers, error) {
+	kinds := make(map[string]string)
+	for _, pair := range strings.Split(conf.Pingers, ",") {
+		if pair == "" {
+			continue
+		}
+		namespace, kind := splitPair(pair)
+		if namespace == "" || kind == "" {
+			return nil, fmt.Errorf("invalid pinger definition %q, expected namespace=pinger", pair)
+		}
+		kinds[namespace] = kind
+	}
+
+	// standard health checks and HTTP probes are not authenticated, so the secret would protect nothing
+	if conf.PingAuthSecret != "" {
+		used := []string{conf.Pinger}
+		for _, kind := range kinds {
+			used = append(used, kind)
+		}
+		for _, kind := range used {
+			if kind != grpcPinger {
+				return nil, fmt.Errorf("ping authentication is supported only by the %s pinger, got %s", grpcPinger, kind)
+			}
+		}
+	}
+
+	return &pingers{
+		conf:        conf,
+		pingTimeout: pingTimeout,
+		kinds:       kinds,
+		instances:   make(map[string]pinger.Pinger),
+	}, nil
+}
+
+// forNamespace returns Pinger configured for the services namespace.
+func (ps *pingers) forNamespace(serviceNamespace string) (pinger.Pinger, error) {
+	kind, ok := ps.kinds[serviceNamespace]
+	if !ok {
+		kind = ps.conf.Pinger
+	}
+	if p, ok := ps.instances[kind]; ok {
+		return p, nil
+	}
+
+	p, err := ps.create(kind)
+	if err != nil {
+		return nil, err
+	}
+	ps.instances[kind] = p
+
+	return p, nil
+}
+
+func (ps *pingers) create(kind string) (pinger.Pinger, error) {
+	switch kind {
+	case grpcPinger:
+		opts, err := ps.grpcOptions()
+		if err != nil {
+			return nil, err
+		}
+		return grpcping.NewPinger(ps.keepaliveParams(), opts...), nil
+	case grpcHealthPinger:
+		opts, err := ps.grpcOptions()
+		if err != nil {
+			return nil, err
+		}
+		return grpcping.NewHealthPinger(ps.keepaliveParams(), ps.conf.GRPCHealthService, opts...), nil
+	case httpPinger:
+		var codes []int
+		for _, c := range strings.Split(ps.conf.HTTPPingCodes, ",") {
+			if c == "" {
+				continue
+			}
+			code, err := strconv.Atoi(strings.TrimSpace(c))
+			if err != nil {
+				return nil, fmt.Errorf("invalid HTTP health check status code %q", c)
+			}
+			codes = append(codes, code)
+		}
+		return httpping.NewPinger(httpping.Config{
+			Scheme:        ps.conf.HTTPPingScheme,
+			Path:          ps.conf.HTTPPingPath,
+			ExpectedCodes: codes,
+			ExpectedBody:  ps.conf.HTTPPingBody,
+			Timeout:       ps.pingTimeout,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown pinger type %q", kind)
+	}
+}
+
+func (ps *pingers) keepaliveParams() keepalive.ClientParameters {
+	if ps.conf.KATime == 0 {
+		ps.conf.KATime = 10 * time.Second
+	}
+	if ps.conf.KATimeout == 0 {
+		ps.conf.KATimeout = 20 * time.Second
+	}
+
+	return keepalive.ClientParameters{
+		Time:                ps.conf.KATime,    // default infinity
+		Timeout:             ps.conf.KATimeout, // default 20s
+		PermitWithoutStream: ps.conf.KAPermitWithoutStream,
+	}
+}
+
+func (ps *pingers) grpcOptions() ([]grpcping.Option, error) {
+	var opts []grpcping.Option
+
+	if ps.conf.PingTLS {
+		var rootCAs []string
+		if ps.conf.PingRootCACerts != "" {
+			rootCAs = strings.Split(ps.conf.PingRootCACerts, ",")
+		}
+
+		tlsCreds, err := creds.ClientCredentials(creds.Config{
+			RootCACerts:        rootCAs,
+			CertFile:           ps.conf.PingClientCert,
+			KeyFile:            ps.conf.PingClientKey,
+			ServerNameOverride: ps.conf.PingServerName,
+			ReloadInterval:     ps.conf.PingCertReloadInterval,
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpcping.WithTransportCredentials(tlsCreds))
+	}
+
+	if ps.conf.PingAuthSecret != "" {
+		h, err := auth.NewHMAC([]byte(ps.conf.PingAuthSecret), 0)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpcping.WithHMACAuth(h))
+	}
+
+	return opts, nil
+}
+
+// splitPair splits key=value pair.
+func splitPair(pair string) (string, string) {
+	kv := strings.SplitN(pair, "=", 2)
+	if len(kv) != 2 {
+		return "", ""
+	}
+
+	return strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+}
@@ -0,0 +1,225 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultPlanInterval = 10 * time.Second // default interval between plan steps
+
+// Budget limits how many work units can be moved from one live service to another at once.
+// Work units of failed services and new work units are assigned regardless of the budget.
+type Budget struct {
+	MaxMoves         int           // max number of work units moved per step, unlimited if zero
+	MaxChangePercent float64       // max percent of the table work units moved per step, unlimited if zero
+	Interval         time.Duration // interval between plan steps
+}
+
+// unlimited reports whether the budget allows to apply any table at once.
+func (b Budget) unlimited() bool {
+	return b.MaxMoves <= 0 && b.MaxChangePercent <= 0
+}
+
+// limit returns the max number of work units moved per step for the table of total work units.
+func (b Budget) limit(total int) int {
+	limit := -1
+	if b.MaxMoves > 0 {
+		limit = b.MaxMoves
+	}
+	if b.MaxChangePercent > 0 {
+		byPercent := int(float64(total) * b.MaxChangePercent / 100)
+		if byPercent < 1 {
+			byPercent = 1 // always make progress
+		}
+		if limit == -1 || byPercent < limit {
+			limit = byPercent
+		}
+	}
+
+	return limit
+}
+
+// Plan is a staged migration from the current matching table to the target one.
+type Plan struct {
+	mu      sync.RWMutex // mutex for plan fields
+	target  map[string][]string
+	total   int // number of moves required when the target was set
+	done    int // number of moves made since the target was set
+	pending int // number of moves left
+	started time.Time
+}
+
+// PlanStatus describes the progress of the Distributor plan.
+type PlanStatus struct {
+	Namespace string    `json:"namespace"`
+	Total     int       `json:"total"`
+	Done      int       `json:"done"`
+	Pending   int       `json:"pending"`
+	Started   time.Time `json:"started,omitempty"`
+}
+
+// owners returns service of every work unit in the table.
+func owners(table map[string][]string) map[string]string {
+	res := make(map[string]string)
+	for service, units := range table {
+		for _, unit := range units {
+			res[unit] = service
+		}
+	}
+
+	return res
+}
+
+// countMoves returns the number of work units which change their live service between tables.
+func countMoves(current, target map[string][]string) int {
+	currentOwners := owners(current)
+	moves := 0
+	for service, units := range target {
+		for _, unit := range units {
+			owner, ok := currentOwners[unit]
+			if _, alive := target[owner]; ok && alive && owner != service {
+				moves++
+			}
+		}
+	}
+
+	return moves
+}
+
+// step returns the table made from current by at most limit moves towards target (any number if limit is negative)
+// and the number of made moves. Work units without owner in target services are moved regardless of limit.
+func step(current, target map[string][]string, limit int) (map[string][]string, int) {
+	currentOwners := owners(current)
+
+	var units []string
+	targetOwners := owners(target)
+	for unit := range targetOwners {
+		units = append(units, unit)
+	}
+	sort.Strings(units) // moves are made in a consistent order
+
+	next := make(map[string][]string, len(target))
+	for service := range target {
+		next[service] = []string{}
+	}
+
+	moves := 0
+	for _, unit := range units {
+		service := targetOwners[unit]
+		owner, ok := currentOwners[unit]
+		if _, alive := target[owner]; ok && alive && owner != service {
+			if limit >= 0 && moves >= limit {
+				service = owner // keep on the current service until the next step
+			} else {
+				moves++
+			}
+		}
+		next[service] = append(next[service], unit)
+	}
+
+	return next, moves
+}
+
+// setTarget starts migration to the target table. Work units without live owners are assigned at once,
+// moves between live services are left to plan steps, so rebalancing several times per interval doesn't exceed the budget.
+func (d *Distributor) setTarget(target map[string][]string) error {
+	total := countMoves(d.currentTable(), target)
+
+	d.plan.mu.Lock()
+	d.plan.target = target
+	d.plan.total = total
+	d.plan.done = 0
+	d.plan.pending = total
+	d.plan.started = time.Now()
+	d.plan.mu.Unlock()
+
+	if total != 0 {
+		logrus.Infof("start moving %d work units between services of the %s namespace", total, d.serviceNamespace)
+	}
+	planTotalMoves.WithLabelValues(d.serviceNamespace).Set(float64(total))
+
+	return d.applyPlan(false)
+}
+
+// stepPlan moves work units towards the target table within the budget.
+func (d *Distributor) stepPlan() error {
+	return d.applyPlan(true)
+}
+
+// applyPlan writes the table with work units without live owners assigned to their target services,
+// other work units are moved within the budget if move, kept on their current services otherwise.
+func (d *Distributor) applyPlan(move bool) error {
+	d.plan.mu.RLock()
+	target := d.plan.target
+	d.plan.mu.RUnlock()
+	if target == nil {
+		return nil
+	}
+
+	current := d.currentTable()
+	total := 0
+	for _, units := range target {
+		total += len(units)
+	}
+
+	limit := 0
+	if move {
+		limit = d.budget.limit(total)
+	}
+	next, moves := step(current, target, limit)
+	if err := d.writeTable(next); err != nil {
+		return err
+	}
+	pending := countMoves(next, target)
+
+	d.plan.mu.Lock()
+	d.plan.done += moves
+	d.plan.pending = pending
+	if pending == 0 {
+		d.plan.target = nil
+	}
+	d.plan.mu.Unlock()
+
+	if moves != 0 {
+		logrus.Infof("moved %d work units between services of the %s namespace, %d left", moves, d.serviceNamespace, pending)
+	}
+	planMoves.WithLabelValues(d.serviceNamespace).Add(float64(moves))
+	planPendingMoves.WithLabelValues(d.serviceNamespace).Set(float64(pending))
+
+	return nil
+}
+
+// PlanStatus returns the progress of migration to the target matching table.
+func (d *Distributor) PlanStatus() PlanStatus {
+	d.plan.mu.RLock()
+	defer d.plan.mu.RUnlock()
+
+	return PlanStatus{
+		Namespace: d.serviceNamespace,
+		Total:     d.plan.total,
+		Done:      d.plan.done,
+		Pending:   d.plan.pending,
+		Started:   d.plan.started,
+	}
+}
+
+// planHandler responds with plans progress of all distributors.
+func planHandler(distributors []*Distributor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]PlanStatus, 0, len(distributors))
+		for _, d := range distributors {
+			statuses = append(statuses, d.PlanStatus())
+		}
+
+		writeJSON(w, statuses)
+	}
+}
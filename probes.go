@@ -0,0 +1,245 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultStallTimeout = time.Minute
+
+// cycleStats are timings of liveness checks of the Distributor.
+type cycleStats struct {
+	mu        sync.RWMutex // mutex for fields below
+	created   time.Time    // creation of the Distributor
+	started   time.Time    // start of the running check, zero if no check is running
+	finished  time.Time    // end of the last check
+	succeeded time.Time    // end of the last successful check
+	lastErr   error        // error of the last failed check
+	failures  int          // number of checks failed in a row
+}
+
+func (c *cycleStats) start() {
+	c.mu.Lock()
+	c.started = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *cycleStats) finish(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.started = time.Time{}
+	c.finished = time.Now()
+	if err != nil {
+		c.lastErr = err
+		c.failures++
+		return
+	}
+	c.succeeded = c.finished
+	c.failures = 0
+}
+
+// stalled reports whether the running check takes longer than timeout or no check finished within timeout.
+func (c *cycleStats) stalled(timeout time.Duration, now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.started.IsZero() {
+		return now.Sub(c.started) > timeout
+	}
+	last := c.finished
+	if last.IsZero() {
+		last = c.created
+	}
+
+	return now.Sub(last) > timeout
+}
+
+// GroupStatus is the status of a distribution group reported by the debug status page.
+type GroupStatus struct {
+	Namespace        string               `json:"namespace"`
+	ServicesKey      string               `json:"services_key"`
+	WorkUnitsKey     string               `json:"work_units_key"`
+	TableKey         string               `json:"table_key"`
+	AliveServices    int                  `json:"alive_services"`
+	DeadServices     map[string]time.Time `json:"dead_services,omitempty"` // dead services with times of their first failed pings
+	WorkUnits        int                  `json:"work_units"`
+	Table            map[string]int       `json:"table"` // number of work units of every service
+	States           map[string]string    `json:"states,omitempty"`
+	Plan             PlanStatus           `json:"plan"`
+	LastCheck        time.Time            `json:"last_check,omitempty"`
+	LastSuccess      time.Time            `json:"last_success,omitempty"`
+	LastError        string               `json:"last_error,omitempty"`
+	FailuresInRow    int                  `json:"failures_in_row"`
+	Stalled          bool                 `json:"stalled"`
+	UnassignedUnits  []string             `json:"unassigned_units,omitempty"`
+	CheckRunningFrom time.Time            `json:"check_running_from,omitempty"`
+}
+
+// Status returns the status of the distribution group of the Distributor.
+func (d *Distributor) Status(stallTimeout time.Duration) GroupStatus {
+	status := GroupStatus{
+		Namespace:     d.serviceNamespace,
+		ServicesKey:   d.servicesKey,
+		WorkUnitsKey:  d.ringMembers,
+		TableKey:      d.distributionNamespace,
+		AliveServices: len(d.serviceCache.all()),
+		WorkUnits:     len(d.workUnitsCache.all()),
+		Table:         make(map[string]int),
+		DeadServices:  d.DeadServices(),
+		Plan:          d.PlanStatus(),
+		Stalled:       d.cycles.stalled(stallTimeout, time.Now()),
+	}
+
+	table := d.currentTable()
+	for service, units := range table {
+		status.Table[service] = len(units)
+	}
+	assigned := owners(table)
+	for _, unit := range d.workUnitsCache.all() {
+		if _, ok := assigned[unit]; !ok {
+			status.UnassignedUnits = append(status.UnassignedUnits, unit)
+		}
+	}
+	sort.Strings(status.UnassignedUnits)
+
+	d.statesMu.RLock()
+	if len(d.states) != 0 {
+		status.States = make(map[string]string, len(d.states))
+		for service, state := range d.states {
+			status.States[service] = state
+		}
+	}
+	d.statesMu.RUnlock()
+
+	d.cycles.mu.RLock()
+	status.LastCheck = d.cycles.finished
+	status.LastSuccess = d.cycles.succeeded
+	status.FailuresInRow = d.cycles.failures
+	status.CheckRunningFrom = d.cycles.started
+	if d.cycles.lastErr != nil && d.cycles.failures != 0 {
+		status.LastError = d.cycles.lastErr.Error()
+	}
+	d.cycles.mu.RUnlock()
+
+	return status
+}
+
+// probeCheck is a result of one check of the probe.
+type probeCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// probeResult is the response of the probe, the status is "ok" if all checks passed and "fail" otherwise.
+type probeResult struct {
+	Status string       `json:"status"`
+	Checks []probeCheck `json:"checks"`
+}
+
+// probes serve liveness and readiness probes of orchestration and the debug status page.
+//
+//	GET /healthz       fails if the liveness check loop of any distribution group is stalled, so the Distributor is restarted
+//	GET /readyz        fails if the storage is unavailable
+//	                   or any distribution group had no successful liveness check within the stall timeout
+//	GET /debug/status  storage health and status of every distribution group
+//
+// The Distributor has no leader election: every instance manages its distribution groups on its own,
+// so readiness doesn't depend on leader status and the probes report none.
+type probes struct {
+	health       *StorageHealth
+	distributors []*Distributor
+	stallTimeout time.Duration
+}
+
+func newProbes(health *StorageHealth, distributors []*Distributor, stallTimeout time.Duration) *probes {
+	if stallTimeout == 0 {
+		stallTimeout = defaultStallTimeout
+	}
+
+	return &probes{health: health, distributors: distributors, stallTimeout: stallTimeout}
+}
+
+func (p *probes) healthz(w http.ResponseWriter, r *http.Request) {
+	p.respond(w, p.loopChecks(time.Now()))
+}
+
+func (p *probes) readyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	storageStatus := p.health.Status()
+	checks := []probeCheck{
+		{Name: "storage", OK: storageStatus.Healthy, Message: storageStatus.LastError},
+	}
+	if storageStatus.Healthy {
+		checks[0].Message = ""
+	}
+	checks = append(checks, p.loopChecks(now)...)
+	for _, d := range p.distributors {
+		d.cycles.mu.RLock()
+		succeeded := d.cycles.succeeded
+		d.cycles.mu.RUnlock()
+		check := probeCheck{Name: "last-success:" + d.serviceNamespace, OK: now.Sub(succeeded) <= p.stallTimeout}
+		if succeeded.IsZero() {
+			check.Message = "no successful liveness check yet"
+		} else if !check.OK {
+			check.Message = fmt.Sprintf("last successful liveness check %s ago", now.Sub(succeeded).Round(time.Second))
+		}
+		checks = append(checks, check)
+	}
+
+	p.respond(w, checks)
+}
+
+// loopChecks checks that liveness check loops of distribution groups are not stalled.
+func (p *probes) loopChecks(now time.Time) []probeCheck {
+	checks := make([]probeCheck, 0, len(p.distributors))
+	for _, d := range p.distributors {
+		check := probeCheck{Name: "loop:" + d.serviceNamespace, OK: !d.cycles.stalled(p.stallTimeout, now)}
+		if !check.OK {
+			check.Message = fmt.Sprintf("no liveness check finished within %s", p.stallTimeout)
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+func (p *probes) respond(w http.ResponseWriter, checks []probeCheck) {
+	res := probeResult{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			res.Status = "fail"
+			code = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	writeJSONCode(w, code, res)
+}
+
+func (p *probes) status(w http.ResponseWriter, r *http.Request) {
+	res := struct {
+		Version string        `json:"version"`
+		Storage StorageStatus `json:"storage"`
+		Groups  []GroupStatus `json:"groups"`
+	}{
+		Version: Version,
+		Storage: p.health.Status(),
+		Groups:  make([]GroupStatus, 0, len(p.distributors)),
+	}
+	for _, d := range p.distributors {
+		res.Groups = append(res.Groups, d.Status(p.stallTimeout))
+	}
+
+	writeJSON(w, res)
+}
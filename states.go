@@ -0,0 +1,137 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/scientificideas/distributor/storage"
+)
+
+// Service states set by operators. Services without a state are active.
+const (
+	StateActive   = "active"   // the service gets work
+	StateCordoned = "cordoned" // the service is pinged but gets no new work, existing work is kept if configured
+	StateDraining = "draining" // the service is pinged but all its work is moved to other services
+)
+
+// ServiceStates returns states of services set by operators: service -> state.
+func (d *Distributor) ServiceStates() (map[string]string, error) {
+	if d.statesKey == "" {
+		return map[string]string{}, nil
+	}
+
+	return storage.GetMap(d.Storage, d.statesKey)
+}
+
+// SetServiceState sets the state of the registered service, the active state removes the one set before.
+func (d *Distributor) SetServiceState(service, state string) error {
+	if d.statesKey == "" {
+		return fmt.Errorf("service states are disabled for the %s namespace", d.serviceNamespace)
+	}
+
+	services, err := d.Services()
+	if err != nil {
+		return err
+	}
+	if !includes(services, service) {
+		return fmt.Errorf("service %s is not registered in the %s namespace", service, d.serviceNamespace)
+	}
+
+	switch state {
+	case StateActive:
+		return d.Storage.DelFromMap(d.statesKey, service)
+	case StateCordoned, StateDraining:
+		return d.Storage.SetMap(d.statesKey, map[string]interface{}{service: state})
+	default:
+		return fmt.Errorf("unknown service state %q", state)
+	}
+}
+
+// inactiveUnits splits services into the active ones and the others, and returns work units kept by the others:
+// current work units of cordoned services if the Distributor keeps them, nothing for other services.
+// Every inactive service has an entry in the returned table, so its work units are moved within the budget.
+func (d *Distributor) inactiveUnits(services []string) ([]string, map[string][]string, error) {
+	states, err := d.ServiceStates()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.statesMu.Lock()
+	d.states = states
+	d.statesMu.Unlock()
+
+	var active []string
+	kept := make(map[string][]string)
+	for _, service := range services {
+		switch states[service] {
+		case "", StateActive:
+			active = append(active, service)
+		case StateCordoned:
+			kept[service] = []string{}
+			if d.keepCordonedUnits {
+				kept[service] = append(kept[service], d.assignedUnits(service)...)
+			}
+		default:
+			kept[service] = []string{}
+		}
+	}
+
+	return active, kept, nil
+}
+
+// statesChanged reports whether service states in storage differ from the ones applied by the last distribution.
+func (d *Distributor) statesChanged() (bool, error) {
+	states, err := d.ServiceStates()
+	if err != nil {
+		return false, err
+	}
+
+	d.statesMu.RLock()
+	defer d.statesMu.RUnlock()
+
+	return !equalMaps(states, d.states), nil
+}
+
+// serviceState returns the state of the service applied by the last distribution.
+func (d *Distributor) serviceState(service string) string {
+	d.statesMu.RLock()
+	defer d.statesMu.RUnlock()
+
+	if state, ok := d.states[service]; ok {
+		return state
+	}
+
+	return StateActive
+}
+
+// splitInactive splits the table into tables of active and inactive services.
+func (d *Distributor) splitInactive(table map[string][]string) (map[string][]string, map[string][]string) {
+	active := make(map[string][]string, len(table))
+	inactive := make(map[string][]string)
+	for service, units := range table {
+		if d.serviceState(service) == StateActive {
+			active[service] = units
+		} else {
+			inactive[service] = units
+		}
+	}
+
+	return active, inactive
+}
+
+func equalMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,112 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/scientificideas/distributor/pinger"
+	"github.com/sirupsen/logrus"
+)
+
+// StatusCache keeps the latest statuses reported by services in ping responses.
+type StatusCache struct {
+	mu       sync.RWMutex // mutex for statuses map
+	statuses map[string]*pinger.Status
+}
+
+// eligible reports whether the service with this status can get work.
+// Services which don't report status are always eligible.
+func eligible(status *pinger.Status) bool {
+	return status == nil || (status.Ready && !status.Draining)
+}
+
+// set saves service status and reports whether the service eligibility for work changed.
+func (s *StatusCache) set(service string, status *pinger.Status) bool {
+	s.mu.Lock()
+	prev := s.statuses[service]
+	if status == nil {
+		delete(s.statuses, service)
+	} else {
+		s.statuses[service] = status
+	}
+	s.mu.Unlock()
+
+	return eligible(prev) != eligible(status)
+}
+
+func (s *StatusCache) get(service string) *pinger.Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.statuses[service]
+}
+
+func (s *StatusCache) del(service string) {
+	s.mu.Lock()
+	delete(s.statuses, service)
+	s.mu.Unlock()
+}
+
+// ping checks service liveness. If the pinger receives service statuses, ping verifies the service identity,
+// saves the status and reports work units processed by the service without being assigned to it.
+// It returns true if the service eligibility for work changed.
+func (d *Distributor) ping(ctx context.Context, service string) (bool, error) {
+	sp, ok := d.p.(pinger.StatusPinger)
+	if !ok {
+		return false, d.p.Ping(ctx, service)
+	}
+
+	status, err := sp.PingStatus(ctx, service)
+	if err != nil {
+		return false, err
+	}
+
+	if status != nil {
+		if status.ServiceID != "" && status.ServiceID != service {
+			return false, fmt.Errorf("service %s answered as %s", service, status.ServiceID)
+		}
+		d.checkUnits(service, status.Units)
+	}
+
+	return d.statusCache.set(service, status), nil
+}
+
+// checkUnits reports work units processed by the service but not assigned to it in the matching table.
+func (d *Distributor) checkUnits(service string, units []string) {
+	assigned := make(map[string]struct{})
+	for _, unit := range d.assignedUnits(service) {
+		assigned[unit] = struct{}{}
+	}
+
+	var foreign []string
+	for _, unit := range units {
+		if _, ok := assigned[unit]; !ok {
+			foreign = append(foreign, unit)
+		}
+	}
+
+	if len(foreign) != 0 {
+		logrus.Warnf("service %s of the %s namespace processes work units not assigned to it: %v", service, d.serviceNamespace, foreign)
+	}
+	foreignUnits.WithLabelValues(d.serviceNamespace, service).Set(float64(len(foreign)))
+}
+
+// eligibleServices filters out services which reported they are not ready for work or draining.
+func (d *Distributor) eligibleServices(services []string) []string {
+	var res []string
+	for _, service := range services {
+		if eligible(d.statusCache.get(service)) {
+			res = append(res, service)
+		} else {
+			logrus.Debugf("service %s of the %s namespace is not eligible for work", service, d.serviceNamespace)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,334 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltLists    = []byte("lists")    // bucket of list buckets: key -> sequence -> item
+	boltMaps     = []byte("maps")     // bucket of map buckets: key -> field -> value
+	boltCounters = []byte("counters") // bucket of counters: key -> value
+)
+
+var _ Extended = (*Bolt)(nil)
+
+// Bolt implements Distributor Storage interface with the embedded bbolt database file
+// for single-node deployments without external storage. Services can't access the file,
+// so they use the storage HTTP API of the Distributor instead.
+type Bolt struct {
+	DB       *bolt.DB
+	watchers watchers
+}
+
+// NewBolt opens or creates the database file.
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltLists, boltMaps, boltCounters} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{DB: db}, nil
+}
+
+// GetList returns items of the list stored for key in the order they were added.
+func (b *Bolt) GetList(key string) ([]string, error) {
+	var items []string
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		list := tx.Bucket(boltLists).Bucket([]byte(key))
+		if list == nil {
+			return nil
+		}
+		return list.ForEach(func(_, item []byte) error {
+			items = append(items, string(item))
+			return nil
+		})
+	})
+
+	return items, err
+}
+
+// AddToList adds item to the list stored for key if it's not there yet.
+func (b *Bolt) AddToList(key, item string) error {
+	return b.Tx(func(tx Tx) error {
+		tx.AddToList(key, item)
+		return nil
+	})
+}
+
+// DelFromList removes item from the list.
+func (b *Bolt) DelFromList(listname, item string) error {
+	return b.Tx(func(tx Tx) error {
+		tx.DelFromList(listname, item)
+		return nil
+	})
+}
+
+// listIndex returns the index of item in the list bucket, nil if it's absent.
+func listIndex(list *bolt.Bucket, item string) []byte {
+	c := list.Cursor()
+	for index, value := c.First(); index != nil; index, value = c.Next() {
+		if string(value) == item {
+			return append([]byte{}, index...)
+		}
+	}
+
+	return nil
+}
+
+// SetMap creates or updates map fields in one transaction.
+func (b *Bolt) SetMap(key string, m map[string]interface{}) error {
+	return b.UpdateMaps(map[string]map[string]interface{}{key: m}, nil)
+}
+
+// DelFromMap removes the map field.
+func (b *Bolt) DelFromMap(mapname string, field string) error {
+	return b.UpdateMaps(nil, map[string][]string{mapname: {field}})
+}
+
+// GetMapField returns work units of the matching table value for given map field.
+func (b *Bolt) GetMapField(key, field string) ([]string, error) {
+	data, err := b.GetField(key, field)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := DecodeTableValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.Units, nil
+}
+
+// GetField returns the raw value of the map field.
+func (b *Bolt) GetField(key, field string) (string, error) {
+	var value []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		if m := tx.Bucket(boltMaps).Bucket([]byte(key)); m != nil {
+			value = m.Get([]byte(field))
+		}
+		if value == nil {
+			return ErrNotFound
+		}
+		value = append([]byte{}, value...) // the value is valid only during the transaction
+		return nil
+	})
+
+	return string(value), err
+}
+
+// GetMap returns all fields and values of the map.
+func (b *Bolt) GetMap(key string) (map[string]string, error) {
+	res := make(map[string]string)
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		m := tx.Bucket(boltMaps).Bucket([]byte(key))
+		if m == nil {
+			return nil
+		}
+		return m.ForEach(func(field, value []byte) error {
+			res[string(field)] = string(value)
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+// UpdateMaps sets and deletes map fields in one transaction.
+func (b *Bolt) UpdateMaps(set map[string]map[string]interface{}, del map[string][]string) error {
+	return b.Tx(func(tx Tx) error {
+		for key, fields := range set {
+			tx.SetMap(key, fields)
+		}
+		for key, fields := range del {
+			tx.DelFromMap(key, fields...)
+		}
+		return nil
+	})
+}
+
+// ReplaceMap atomically replaces all fields of the map.
+func (b *Bolt) ReplaceMap(key string, m map[string]interface{}) error {
+	return b.Tx(func(tx Tx) error {
+		tx.ReplaceMap(key, m)
+		return nil
+	})
+}
+
+// Tx applies changes made by fn in one bbolt transaction, nothing is written if any change fails.
+func (b *Bolt) Tx(fn func(tx Tx) error) error {
+	btx := &boltTx{}
+	if err := fn(btx); err != nil {
+		return err
+	}
+	if len(btx.ops) == 0 {
+		return nil
+	}
+
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		for _, op := range btx.ops {
+			if err := op(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		b.watchers.notify(btx.keys...)
+	}
+
+	return err
+}
+
+// boltTx collects changes of the transaction, they are applied in one bbolt read-write transaction.
+type boltTx struct {
+	ops  []func(tx *bolt.Tx) error
+	keys []string
+}
+
+func (btx *boltTx) add(key string, op func(tx *bolt.Tx) error) {
+	btx.ops = append(btx.ops, op)
+	btx.keys = append(btx.keys, key)
+}
+
+func (btx *boltTx) AddToList(key, item string) {
+	btx.add(key, func(tx *bolt.Tx) error {
+		list, err := tx.Bucket(boltLists).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		if listIndex(list, item) != nil {
+			return nil
+		}
+
+		seq, err := list.NextSequence()
+		if err != nil {
+			return err
+		}
+		index := make([]byte, 8)
+		binary.BigEndian.PutUint64(index, seq)
+
+		return list.Put(index, []byte(item))
+	})
+}
+
+func (btx *boltTx) DelFromList(key, item string) {
+	btx.add(key, func(tx *bolt.Tx) error {
+		list := tx.Bucket(boltLists).Bucket([]byte(key))
+		if list == nil {
+			return nil
+		}
+		if index := listIndex(list, item); index != nil {
+			return list.Delete(index)
+		}
+		return nil
+	})
+}
+
+func (btx *boltTx) SetMap(key string, m map[string]interface{}) {
+	if len(m) == 0 {
+		return
+	}
+	values := make(map[string]string, len(m))
+	for field, value := range m {
+		values[field] = toString(value)
+	}
+	btx.add(key, func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltMaps).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		for field, value := range values {
+			if err = bucket.Put([]byte(field), []byte(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (btx *boltTx) DelFromMap(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	btx.add(key, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMaps).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		for _, field := range fields {
+			if err := bucket.Delete([]byte(field)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (btx *boltTx) ReplaceMap(key string, m map[string]interface{}) {
+	btx.add(key, func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltMaps).DeleteBucket([]byte(key))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+	btx.SetMap(key, m)
+}
+
+// IncrBy increments the counter.
+func (b *Bolt) IncrBy(key string, n int64) (int64, error) {
+	var value int64
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		counters := tx.Bucket(boltCounters)
+		if data := counters.Get([]byte(key)); data != nil {
+			var err error
+			if value, err = strconv.ParseInt(string(data), 10, 64); err != nil {
+				return err
+			}
+		}
+		value += n
+		return counters.Put([]byte(key), []byte(strconv.FormatInt(value, 10)))
+	})
+
+	return value, err
+}
+
+// Watch notifies about changes of the list or the map made by this process, the database file is locked by it.
+func (b *Bolt) Watch(ctx context.Context, key string) (<-chan struct{}, error) {
+	return b.watchers.add(ctx, key), nil
+}
+
+// Ping checks that the database file is open.
+func (b *Bolt) Ping(ctx context.Context) error {
+	return b.DB.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// Close closes the database file.
+func (b *Bolt) Close() error {
+	return b.DB.Close()
+}
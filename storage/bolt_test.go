@@ -0,0 +1,71 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "distributor.db")
+	b, err := NewBolt(path)
+	require.NoError(t, err)
+	testStorage(t, b)
+
+	require.NoError(t, b.AddToList("services", "service1"))
+	require.NoError(t, b.SetMap("table", map[string]interface{}{"service1": "work1,work2"}))
+	n, err := b.IncrBy("token", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// data survives reopening
+	require.NoError(t, b.Close())
+	b, err = NewBolt(path)
+	require.NoError(t, err)
+	defer b.Close()
+	services, err := b.GetList("services")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service1"}, services)
+	table, err := b.GetMap("table")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service1": "work1,work2"}, table)
+	n, err = b.IncrBy("token", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+}
+
+func TestBoltTxRollback(t *testing.T) {
+	b, err := NewBolt(filepath.Join(t.TempDir(), "distributor.db"))
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, b.SetMap("table", map[string]interface{}{"service1": "work1"}))
+
+	// a change failing in the middle of the transaction discards the changes made before it
+	err = b.Tx(func(tx Tx) error {
+		tx.ReplaceMap("table", map[string]interface{}{"service2": "work1"})
+		tx.SetMap("owners", map[string]interface{}{"work1": "service2"})
+		tx.(*boltTx).add("", func(*bolt.Tx) error { return errors.New("write failed") })
+		tx.AddToList("services", "service2")
+		return nil
+	})
+	require.EqualError(t, err, "write failed")
+
+	table, err := b.GetMap("table")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service1": "work1"}, table)
+	owners, err := b.GetMap("owners")
+	require.NoError(t, err)
+	assert.Empty(t, owners)
+	services, err := b.GetList("services")
+	require.NoError(t, err)
+	assert.Empty(t, services)
+}
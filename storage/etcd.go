@@ -0,0 +1,464 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	defaultEtcdPrefix    = "/distributor"  // default prefix of all keys
+	defaultEtcdMaxTxnOps = 128             // default --max-txn-ops limit of etcd servers
+	etcdRequestTimeout   = 5 * time.Second // timeout of every etcd request
+)
+
+var _ Extended = (*Etcd)(nil)
+
+// Etcd implements Distributor Storage interface for etcd.
+// Every list item is a separate etcd key <prefix>/lists/<key>/<item>, list items are returned in the order they were added.
+// Every map is a single etcd key <prefix>/maps/<key> holding JSON object of its fields, so changes of the matching table
+// and the maps updated with it take one operation per map and are always applied in one transaction.
+type Etcd struct {
+	Client    *clientv3.Client
+	MaxTxnOps int // max number of operations in one transaction, the --max-txn-ops limit of the etcd server
+	prefix    string
+}
+
+// NewEtcd creates etcd storage implementation instance, all keys are stored under prefix (/distributor if empty).
+// With TLS the server certificate is verified with rootCAs files, system root CAs if there are none.
+func NewEtcd(endpoints []string, username, password string, withTLS bool, rootCAs []string, prefix string) (*Etcd, error) {
+	cfg := clientv3.Config{
+		Endpoints:   endpoints,
+		Username:    username,
+		Password:    password,
+		DialTimeout: 10 * time.Second,
+	}
+	if withTLS {
+		tlsConfig, err := clientTLSConfig(rootCAs, "", "")
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLS = tlsConfig
+	}
+
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := NewEtcdWithClient(client, prefix)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DialTimeout)
+	defer cancel()
+	if _, err = client.Get(ctx, e.prefix); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// NewEtcdWithClient creates etcd storage implementation instance using the client.
+func NewEtcdWithClient(client *clientv3.Client, prefix string) *Etcd {
+	if prefix == "" {
+		prefix = defaultEtcdPrefix
+	}
+
+	return &Etcd{Client: client, MaxTxnOps: defaultEtcdMaxTxnOps, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+func (e *Etcd) listKey(key string) string {
+	return e.prefix + "/lists/" + url.PathEscape(key) + "/"
+}
+
+func (e *Etcd) mapKey(key string) string {
+	return e.prefix + "/maps/" + url.PathEscape(key)
+}
+
+func (e *Etcd) counterKey(key string) string {
+	return e.prefix + "/counters/" + url.PathEscape(key)
+}
+
+// GetList returns items of the list stored for key.
+func (e *Etcd) GetList(key string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	resp, err := e.Client.Get(ctx, e.listKey(key), clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		items = append(items, string(kv.Value))
+	}
+
+	return items, nil
+}
+
+// AddToList adds item to the list stored for key.
+func (e *Etcd) AddToList(key, item string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	_, err := e.Client.Put(ctx, e.listKey(key)+item, item)
+
+	return err
+}
+
+// DelFromList removes item from the list.
+func (e *Etcd) DelFromList(listname, item string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	_, err := e.Client.Delete(ctx, e.listKey(listname)+item)
+
+	return err
+}
+
+// RegisterService adds service to the list stored for namespace with the etcd lease of ttl, which is kept alive until
+// ctx is done. If the service stops without unregistering, it's removed from the list when the lease expires.
+func (e *Etcd) RegisterService(ctx context.Context, namespace, service string, ttl time.Duration) error {
+	reqCtx, cancel := context.WithTimeout(ctx, etcdRequestTimeout)
+	defer cancel()
+
+	lease, err := e.Client.Grant(reqCtx, int64(ttl/time.Second))
+	if err != nil {
+		return err
+	}
+	if _, err = e.Client.Put(reqCtx, e.listKey(namespace)+service, service, clientv3.WithLease(lease.ID)); err != nil {
+		return err
+	}
+
+	keepAlive, err := e.Client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range keepAlive {
+		}
+		// the keep alive channel is closed when ctx is done or the lease is lost
+		revokeCtx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+		defer cancel()
+		if _, err := e.Client.Revoke(revokeCtx, lease.ID); err != nil {
+			logrus.Warnf("failed to revoke lease of service %s: %s", service, err)
+		}
+	}()
+
+	return nil
+}
+
+// SetMap creates or updates map fields in one transaction.
+func (e *Etcd) SetMap(key string, m map[string]interface{}) error {
+	return e.UpdateMaps(map[string]map[string]interface{}{key: m}, nil)
+}
+
+// DelFromMap removes the map field.
+func (e *Etcd) DelFromMap(mapname string, field string) error {
+	return e.UpdateMaps(nil, map[string][]string{mapname: {field}})
+}
+
+// GetMapField returns work units of the matching table value for given map field.
+func (e *Etcd) GetMapField(key, field string) ([]string, error) {
+	data, err := e.GetField(key, field)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := DecodeTableValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.Units, nil
+}
+
+// GetField returns the raw value of the map field.
+func (e *Etcd) GetField(key, field string) (string, error) {
+	m, err := e.GetMap(key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := m[field]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+// GetMap returns all fields and values of the map.
+func (e *Etcd) GetMap(key string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	m, _, err := e.getMap(ctx, key)
+
+	return m, err
+}
+
+// getMap returns the map and the revision of its last modification, 0 if it's absent.
+func (e *Etcd) getMap(ctx context.Context, key string) (map[string]string, int64, error) {
+	resp, err := e.Client.Get(ctx, e.mapKey(key))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m := make(map[string]string)
+	if len(resp.Kvs) == 0 {
+		return m, 0, nil
+	}
+	if err = json.Unmarshal(resp.Kvs[0].Value, &m); err != nil {
+		return nil, 0, fmt.Errorf("invalid map %s: %w", key, err)
+	}
+
+	return m, resp.Kvs[0].ModRevision, nil
+}
+
+// UpdateMaps sets and deletes map fields in one transaction.
+func (e *Etcd) UpdateMaps(set map[string]map[string]interface{}, del map[string][]string) error {
+	return e.Tx(func(tx Tx) error {
+		for key, fields := range set {
+			tx.SetMap(key, fields)
+		}
+		for key, fields := range del {
+			tx.DelFromMap(key, fields...)
+		}
+		return nil
+	})
+}
+
+// ReplaceMap atomically replaces all fields of the map.
+func (e *Etcd) ReplaceMap(key string, m map[string]interface{}) error {
+	return e.Tx(func(tx Tx) error {
+		tx.ReplaceMap(key, m)
+		return nil
+	})
+}
+
+// Tx applies changes made by fn in one etcd transaction. Changed maps are read and written back
+// only if they were not modified meanwhile, otherwise the changes are applied to the new maps again.
+// Changes taking more than MaxTxnOps operations, one per changed map or list item, are rejected.
+func (e *Etcd) Tx(fn func(tx Tx) error) error {
+	tx := &etcdTx{e: e, items: make(map[string]clientv3.Op)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.items) == 0 && len(tx.maps) == 0 {
+		return nil
+	}
+
+	limit := e.MaxTxnOps
+	if limit <= 0 {
+		limit = defaultEtcdMaxTxnOps
+	}
+	if n := len(tx.items) + len(tx.maps); n > limit {
+		return fmt.Errorf("transaction of %d operations exceeds the limit of %d operations", n, limit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	for {
+		conditions := make([]clientv3.Cmp, 0, len(tx.maps))
+		ops := make([]clientv3.Op, 0, len(tx.items)+len(tx.maps))
+		for _, op := range tx.items {
+			ops = append(ops, op)
+		}
+		for _, key := range tx.mapKeys {
+			m, revision, err := e.getMap(ctx, key)
+			if err != nil {
+				return err
+			}
+			for _, change := range tx.maps[key] {
+				change(m)
+			}
+
+			conditions = append(conditions, clientv3.Compare(clientv3.ModRevision(e.mapKey(key)), "=", revision))
+			if len(m) == 0 {
+				ops = append(ops, clientv3.OpDelete(e.mapKey(key)))
+				continue
+			}
+			data, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, clientv3.OpPut(e.mapKey(key), string(data)))
+		}
+
+		resp, err := e.Client.Txn(ctx).If(conditions...).Then(ops...).Commit()
+		if err != nil {
+			return err
+		}
+		if resp.Succeeded {
+			return nil
+		}
+	}
+}
+
+// etcdTx collects changes of the transaction: operations on list items and changes of maps applied to maps read
+// before the commit. Only the last operation on a list item is kept, etcd doesn't allow several in one transaction.
+type etcdTx struct {
+	e       *Etcd
+	items   map[string]clientv3.Op
+	maps    map[string][]func(m map[string]string)
+	mapKeys []string // changed maps in the order of the first change
+}
+
+func (tx *etcdTx) changeMap(key string, change func(m map[string]string)) {
+	if tx.maps == nil {
+		tx.maps = make(map[string][]func(m map[string]string))
+	}
+	if _, ok := tx.maps[key]; !ok {
+		tx.mapKeys = append(tx.mapKeys, key)
+	}
+	tx.maps[key] = append(tx.maps[key], change)
+}
+
+func (tx *etcdTx) AddToList(key, item string) {
+	itemKey := tx.e.listKey(key) + item
+	tx.items[itemKey] = clientv3.OpPut(itemKey, item)
+}
+
+func (tx *etcdTx) DelFromList(key, item string) {
+	itemKey := tx.e.listKey(key) + item
+	tx.items[itemKey] = clientv3.OpDelete(itemKey)
+}
+
+func (tx *etcdTx) SetMap(key string, m map[string]interface{}) {
+	if len(m) == 0 {
+		return
+	}
+	values := make(map[string]string, len(m))
+	for field, value := range m {
+		values[field] = toString(value)
+	}
+	tx.changeMap(key, func(stored map[string]string) {
+		for field, value := range values {
+			stored[field] = value
+		}
+	})
+}
+
+func (tx *etcdTx) DelFromMap(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	tx.changeMap(key, func(stored map[string]string) {
+		for _, field := range fields {
+			delete(stored, field)
+		}
+	})
+}
+
+func (tx *etcdTx) ReplaceMap(key string, m map[string]interface{}) {
+	tx.changeMap(key, func(stored map[string]string) {
+		for field := range stored {
+			delete(stored, field)
+		}
+	})
+	tx.SetMap(key, m)
+}
+
+// IncrBy increments the counter with compare-and-swap transactions.
+func (e *Etcd) IncrBy(key string, n int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	counterKey := e.counterKey(key)
+	for {
+		resp, err := e.Client.Get(ctx, counterKey)
+		if err != nil {
+			return 0, err
+		}
+
+		var value, revision int64
+		if len(resp.Kvs) != 0 {
+			if value, err = strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64); err != nil {
+				return 0, err
+			}
+			revision = resp.Kvs[0].ModRevision
+		}
+		value += n
+
+		txn, err := e.Client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(counterKey), "=", revision)).
+			Then(clientv3.OpPut(counterKey, strconv.FormatInt(value, 10))).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if txn.Succeeded {
+			return value, nil
+		}
+	}
+}
+
+// Watch notifies about changes of the list or the map using etcd watches of their key prefixes.
+func (e *Etcd) Watch(ctx context.Context, key string) (<-chan struct{}, error) {
+	res := make(chan struct{}, 1)
+	lists := e.Client.Watch(ctx, e.listKey(key), clientv3.WithPrefix())
+	maps := e.Client.Watch(ctx, e.mapKey(key))
+
+	go func() {
+		defer close(res)
+		for lists != nil || maps != nil {
+			var ok bool
+			select {
+			case _, ok = <-lists:
+				if !ok {
+					lists = nil
+				}
+			case _, ok = <-maps:
+				if !ok {
+					maps = nil
+				}
+			}
+			if !ok {
+				continue
+			}
+			select {
+			case res <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+// Ping checks the connection to etcd.
+func (e *Etcd) Ping(ctx context.Context) error {
+	_, err := e.Client.Get(ctx, e.prefix, clientv3.WithCountOnly())
+
+	return err
+}
+
+// Close closes connection to etcd.
+func (e *Etcd) Close() error {
+	return e.Client.Close()
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// freeURL returns URL with a free local port.
+func freeURL(t *testing.T) url.URL {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	return url.URL{Scheme: "http", Host: lis.Addr().String()}
+}
+
+// startEtcd starts embedded etcd server and returns the storage connected to it.
+func startEtcd(t *testing.T) *Etcd {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd server is not ready")
+	}
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}, DialTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	e := NewEtcdWithClient(client, "")
+	t.Cleanup(func() { e.Close() })
+
+	return e
+}
+
+func TestEtcd(t *testing.T) {
+	e := startEtcd(t)
+	testStorage(t, e)
+
+	// lists of nested keys are separate
+	require.NoError(t, e.AddToList("services", "service1"))
+	require.NoError(t, e.AddToList("services/other", "service2"))
+	services, err := e.GetList("services")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service1"}, services)
+}
+
+func TestEtcdLargeUpdate(t *testing.T) {
+	e := startEtcd(t)
+
+	// more fields than the default --max-txn-ops limit of the server are changed in one transaction
+	table, owners := make(map[string]interface{}), make(map[string]interface{})
+	for i := 0; i < 300; i++ {
+		unit := fmt.Sprintf("work%d", i)
+		table[fmt.Sprintf("service%d", i)] = unit
+		owners[unit] = fmt.Sprintf(`{"service":"service%d","epoch":1}`, i)
+	}
+	require.NoError(t, e.UpdateMaps(map[string]map[string]interface{}{"table": table, "owners": owners}, nil))
+	stored, err := e.GetMap("table")
+	require.NoError(t, err)
+	assert.Len(t, stored, 300)
+	stored, err = e.GetMap("owners")
+	require.NoError(t, err)
+	assert.Len(t, stored, 300)
+	resp, err := e.Client.Get(context.Background(), e.prefix+"/maps/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.Count, "every map is one key")
+
+	del := make([]string, 0, len(table))
+	for service := range table {
+		del = append(del, service)
+	}
+	require.NoError(t, e.UpdateMaps(nil, map[string][]string{"table": del}))
+	stored, err = e.GetMap("table")
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+
+	// changes over the limit are rejected instead of being split
+	e.MaxTxnOps = 1
+	assert.Error(t, e.UpdateMaps(map[string]map[string]interface{}{"table": table, "owners": owners}, nil))
+	stored, err = e.GetMap("table")
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestEtcdConcurrentUpdates(t *testing.T) {
+	e := startEtcd(t)
+
+	// concurrent changes of different fields of the same map are not lost
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, e.SetMap("table", map[string]interface{}{fmt.Sprintf("service%d", i): "work"}))
+		}(i)
+	}
+	wg.Wait()
+
+	stored, err := e.GetMap("table")
+	require.NoError(t, err)
+	assert.Len(t, stored, 10)
+}
+
+func TestEtcdRegistrationAndWatch(t *testing.T) {
+	e := startEtcd(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := e.Watch(ctx, "services")
+	require.NoError(t, err)
+
+	serviceCtx, stopService := context.WithCancel(context.Background())
+	require.NoError(t, e.RegisterService(serviceCtx, "services", "service1", 5*time.Second))
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("registration is not watched")
+	}
+	services, err := e.GetList("services")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service1"}, services)
+
+	// the stopped service is removed with its lease
+	stopService()
+	assert.Eventually(t, func() bool {
+		services, err := e.GetList("services")
+		return err == nil && len(services) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestEtcdTLSWithoutRootCAs(t *testing.T) {
+	// empty list of root CA files falls back to the system root CAs
+	cfg, err := clientTLSConfig(strings.Split("", ","), "", "")
+	require.NoError(t, err)
+	assert.Nil(t, cfg.RootCAs)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+var _ Extended = (*Memory)(nil)
+
+// Memory implements Distributor Storage interface in memory. It's safe for concurrent use
+// and is used in tests and by embedded Distributors which don't need to persist their data.
+type Memory struct {
+	mu       sync.RWMutex // mutex for lists, maps and counters
+	lists    map[string][]string
+	maps     map[string]map[string]string
+	counters map[string]int64
+	watchers watchers
+}
+
+// NewMemory creates empty in-memory storage.
+func NewMemory() *Memory {
+	return &Memory{
+		lists:    make(map[string][]string),
+		maps:     make(map[string]map[string]string),
+		counters: make(map[string]int64),
+	}
+}
+
+// GetList returns items of the list stored for key in the order they were added.
+func (m *Memory) GetList(key string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.lists[key]) == 0 {
+		return nil, nil
+	}
+
+	return append([]string{}, m.lists[key]...), nil
+}
+
+// AddToList adds item to the list stored for key if it's not there yet.
+func (m *Memory) AddToList(key, item string) error {
+	return m.Tx(func(tx Tx) error {
+		tx.AddToList(key, item)
+		return nil
+	})
+}
+
+// DelFromList removes item from the list.
+func (m *Memory) DelFromList(listname, item string) error {
+	return m.Tx(func(tx Tx) error {
+		tx.DelFromList(listname, item)
+		return nil
+	})
+}
+
+func indexOf(list []string, item string) int {
+	for i, value := range list {
+		if value == item {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// SetMap creates or updates map fields.
+func (m *Memory) SetMap(key string, fields map[string]interface{}) error {
+	return m.UpdateMaps(map[string]map[string]interface{}{key: fields}, nil)
+}
+
+// DelFromMap removes the map field.
+func (m *Memory) DelFromMap(mapname string, field string) error {
+	return m.UpdateMaps(nil, map[string][]string{mapname: {field}})
+}
+
+// GetMapField returns work units of the matching table value for given map field.
+func (m *Memory) GetMapField(key, field string) ([]string, error) {
+	data, err := m.GetField(key, field)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := DecodeTableValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.Units, nil
+}
+
+// GetField returns the raw value of the map field.
+func (m *Memory) GetField(key, field string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.maps[key][field]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+// GetMap returns all fields and values of the map.
+func (m *Memory) GetMap(key string) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make(map[string]string, len(m.maps[key]))
+	for field, value := range m.maps[key] {
+		res[field] = value
+	}
+
+	return res, nil
+}
+
+// UpdateMaps sets and deletes map fields atomically.
+func (m *Memory) UpdateMaps(set map[string]map[string]interface{}, del map[string][]string) error {
+	return m.Tx(func(tx Tx) error {
+		for key, fields := range set {
+			tx.SetMap(key, fields)
+		}
+		for key, fields := range del {
+			tx.DelFromMap(key, fields...)
+		}
+		return nil
+	})
+}
+
+// ReplaceMap atomically replaces all fields of the map.
+func (m *Memory) ReplaceMap(key string, fields map[string]interface{}) error {
+	return m.Tx(func(tx Tx) error {
+		tx.ReplaceMap(key, fields)
+		return nil
+	})
+}
+
+// Tx applies changes made by fn atomically.
+func (m *Memory) Tx(fn func(tx Tx) error) error {
+	tx := &memoryTx{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	for _, op := range tx.ops {
+		op(m)
+	}
+	m.mu.Unlock()
+
+	m.watchers.notify(tx.keys...)
+
+	return nil
+}
+
+// memoryTx collects changes of the transaction, they are applied with the storage lock held.
+type memoryTx struct {
+	ops  []func(m *Memory)
+	keys []string
+}
+
+func (tx *memoryTx) add(key string, op func(m *Memory)) {
+	tx.ops = append(tx.ops, op)
+	tx.keys = append(tx.keys, key)
+}
+
+func (tx *memoryTx) AddToList(key, item string) {
+	tx.add(key, func(m *Memory) {
+		if indexOf(m.lists[key], item) < 0 {
+			m.lists[key] = append(m.lists[key], item)
+		}
+	})
+}
+
+func (tx *memoryTx) DelFromList(key, item string) {
+	tx.add(key, func(m *Memory) {
+		list := m.lists[key]
+		if i := indexOf(list, item); i >= 0 {
+			m.lists[key] = append(list[:i:i], list[i+1:]...)
+		}
+	})
+}
+
+func (tx *memoryTx) SetMap(key string, fields map[string]interface{}) {
+	if len(fields) == 0 {
+		return
+	}
+	values := make(map[string]string, len(fields))
+	for field, value := range fields {
+		values[field] = toString(value)
+	}
+	tx.add(key, func(m *Memory) {
+		if m.maps[key] == nil {
+			m.maps[key] = make(map[string]string, len(values))
+		}
+		for field, value := range values {
+			m.maps[key][field] = value
+		}
+	})
+}
+
+func (tx *memoryTx) DelFromMap(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	tx.add(key, func(m *Memory) {
+		for _, field := range fields {
+			delete(m.maps[key], field)
+		}
+		if len(m.maps[key]) == 0 {
+			delete(m.maps, key)
+		}
+	})
+}
+
+func (tx *memoryTx) ReplaceMap(key string, fields map[string]interface{}) {
+	tx.add(key, func(m *Memory) {
+		delete(m.maps, key)
+	})
+	tx.SetMap(key, fields)
+}
+
+// IncrBy increments the counter.
+func (m *Memory) IncrBy(key string, n int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[key] += n
+
+	return m.counters[key], nil
+}
+
+// Watch notifies about changes of the list or the map made by this storage instance.
+func (m *Memory) Watch(ctx context.Context, key string) (<-chan struct{}, error) {
+	return m.watchers.add(ctx, key), nil
+}
+
+// Ping does nothing, the storage is always available.
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, the data is kept until the storage is garbage collected.
+func (m *Memory) Close() error {
+	return nil
+}
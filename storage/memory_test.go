@@ -0,0 +1,87 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	testStorage(t, m)
+
+	// maps of different keys don't share fields
+	require.NoError(t, m.SetMap("table", map[string]interface{}{"service1": "work1,work2"}))
+	require.NoError(t, m.SetMap("states", map[string]interface{}{"service1": "cordoned"}))
+	require.NoError(t, m.DelFromMap("states", "service1"))
+	units, err := m.GetMapField("table", "service1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"work1", "work2"}, units)
+	require.NoError(t, m.ReplaceMap("table", nil))
+
+	// concurrent writers
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, m.AddToList("services", fmt.Sprintf("service%d", i)))
+			assert.NoError(t, m.SetMap("table", map[string]interface{}{fmt.Sprintf("service%d", i): "work"}))
+			_, err := m.IncrBy("token", 1)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	services, err := m.GetList("services")
+	require.NoError(t, err)
+	assert.Len(t, services, 10)
+	table, err := m.GetMap("table")
+	require.NoError(t, err)
+	assert.Len(t, table, 10)
+	n, err := m.IncrBy("token", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+}
+
+func TestFallbacks(t *testing.T) {
+	// the embedded interfaces hide other capabilities of the in-memory storage
+	m := NewMemory()
+	s := struct {
+		Storage
+		MapGetter
+	}{m, m}
+	require.NoError(t, s.SetMap("table", map[string]interface{}{"service1": "work1", "service2": "work2"}))
+	require.NoError(t, ReplaceMap(s, "table", map[string]interface{}{"service2": "work1", "service3": "work2"}))
+	table, err := s.GetMap("table")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service2": "work1", "service3": "work2"}, table)
+
+	// maps of the minimal storage are changed one by one but can't be read whole
+	minimal := struct{ Storage }{m}
+	require.NoError(t, UpdateMaps(minimal,
+		map[string]map[string]interface{}{"table": {"service1": "work3"}, "owners": {"work3": "service1"}},
+		map[string][]string{"table": {"service2", "service3"}},
+	))
+	table, err = m.GetMap("table")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service1": "work3"}, table)
+	owners, err := m.GetMap("owners")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"work3": "service1"}, owners)
+	_, err = GetMap(minimal, "table")
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.ErrorIs(t, ReplaceMap(minimal, "table", nil), ErrNotSupported)
+
+	assert.ErrorIs(t, AddToList(minimal, "services", "service1"), ErrNotSupported)
+	_, err = Watch(context.Background(), minimal, "services")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
@@ -0,0 +1,41 @@
+-- lists: services lists and work units lists
+CREATE TABLE IF NOT EXISTS distributor_lists (
+    id   BIGSERIAL PRIMARY KEY,
+    key  TEXT NOT NULL,
+    item TEXT NOT NULL,
+    UNIQUE (key, item)
+);
+
+-- maps: matching tables (assignments), reverse indexes, leases, overrides and service states
+CREATE TABLE IF NOT EXISTS distributor_maps (
+    key   TEXT NOT NULL,
+    field TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (key, field)
+);
+
+-- counters: fencing tokens
+CREATE TABLE IF NOT EXISTS distributor_counters (
+    key   TEXT PRIMARY KEY,
+    value BIGINT NOT NULL
+);
+
+-- notify listeners about changed keys, notifications of one transaction are delivered on commit without duplicates
+CREATE OR REPLACE FUNCTION distributor_notify() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('distributor_changes', OLD.key);
+    ELSE
+        PERFORM pg_notify('distributor_changes', NEW.key);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS distributor_lists_notify ON distributor_lists;
+CREATE TRIGGER distributor_lists_notify AFTER INSERT OR UPDATE OR DELETE ON distributor_lists
+    FOR EACH ROW EXECUTE PROCEDURE distributor_notify();
+
+DROP TRIGGER IF EXISTS distributor_maps_notify ON distributor_maps;
+CREATE TRIGGER distributor_maps_notify AFTER INSERT OR UPDATE OR DELETE ON distributor_maps
+    FOR EACH ROW EXECUTE PROCEDURE distributor_notify();
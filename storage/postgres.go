@@ -0,0 +1,374 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	postgresChannel     = "distributor_changes" // channel of change notifications
+	postgresMigrateLock = 7300431               // advisory lock key of migrations
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+var _ Extended = (*Postgres)(nil)
+
+// Postgres implements Distributor Storage interface for PostgreSQL. Lists (services and work units) are stored
+// in the distributor_lists table and maps (matching tables and other hashes) in the distributor_maps table,
+// every change of them is notified in the distributor_changes channel with the changed key as payload.
+type Postgres struct {
+	DB  *sql.DB
+	dsn string
+
+	mu       sync.Mutex // mutex for listener
+	listener *pq.Listener
+	watchers watchers
+}
+
+// NewPostgres connects to PostgreSQL with the data source name and applies migrations.
+func NewPostgres(dsn string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Postgres{DB: db, dsn: dsn}
+	if err = p.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// migrate applies migrations which are not applied yet in the order of their version prefixes.
+func (p *Postgres) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if _, err := p.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS distributor_migrations (
+		version    INT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	files, err := postgresMigrations.ReadDir("migrations/postgres")
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, file := range files {
+		version, err := strconv.Atoi(strings.SplitN(file.Name(), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("invalid migration name %s", file.Name())
+		}
+		migration, err := postgresMigrations.ReadFile(path.Join("migrations/postgres", file.Name()))
+		if err != nil {
+			return err
+		}
+		if err = p.applyMigration(ctx, version, string(migration)); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", file.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Postgres) applyMigration(ctx context.Context, version int, migration string) error {
+	return p.tx(ctx, func(tx *sql.Tx) error {
+		// distributors started at once apply migrations one by one
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, postgresMigrateLock); err != nil {
+			return err
+		}
+
+		var applied bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM distributor_migrations WHERE version = $1)`, version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, migration); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO distributor_migrations (version) VALUES ($1)`, version); err != nil {
+			return err
+		}
+		logrus.Infof("applied PostgreSQL migration %d", version)
+
+		return nil
+	})
+}
+
+// tx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise.
+func (p *Postgres) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetList returns items of the list stored for key in the order they were added.
+func (p *Postgres) GetList(key string) ([]string, error) {
+	rows, err := p.DB.Query(`SELECT item FROM distributor_lists WHERE key = $1 ORDER BY id`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []string
+	for rows.Next() {
+		var item string
+		if err = rows.Scan(&item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// AddToList adds item to the list stored for key if it's not there yet.
+func (p *Postgres) AddToList(key, item string) error {
+	_, err := p.DB.Exec(`INSERT INTO distributor_lists (key, item) VALUES ($1, $2) ON CONFLICT (key, item) DO NOTHING`, key, item)
+
+	return err
+}
+
+// DelFromList removes item from the list.
+func (p *Postgres) DelFromList(listname, item string) error {
+	_, err := p.DB.Exec(`DELETE FROM distributor_lists WHERE key = $1 AND item = $2`, listname, item)
+
+	return err
+}
+
+// SetMap creates or updates map fields in one transaction.
+func (p *Postgres) SetMap(key string, m map[string]interface{}) error {
+	return p.UpdateMaps(map[string]map[string]interface{}{key: m}, nil)
+}
+
+// DelFromMap removes the map field.
+func (p *Postgres) DelFromMap(mapname string, field string) error {
+	_, err := p.DB.Exec(`DELETE FROM distributor_maps WHERE key = $1 AND field = $2`, mapname, field)
+
+	return err
+}
+
+// GetMapField returns work units of the matching table value for given map field.
+func (p *Postgres) GetMapField(key, field string) ([]string, error) {
+	data, err := p.GetField(key, field)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := DecodeTableValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return value.Units, nil
+}
+
+// GetField returns the raw value of the map field.
+func (p *Postgres) GetField(key, field string) (string, error) {
+	var value string
+	err := p.DB.QueryRow(`SELECT value FROM distributor_maps WHERE key = $1 AND field = $2`, key, field).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+
+	return value, err
+}
+
+// GetMap returns all fields and values of the map.
+func (p *Postgres) GetMap(key string) (map[string]string, error) {
+	rows, err := p.DB.Query(`SELECT field, value FROM distributor_maps WHERE key = $1`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]string)
+	for rows.Next() {
+		var field, value string
+		if err = rows.Scan(&field, &value); err != nil {
+			return nil, err
+		}
+		res[field] = value
+	}
+
+	return res, rows.Err()
+}
+
+// UpdateMaps sets and deletes map fields in one transaction, so the matching table is replaced atomically.
+func (p *Postgres) UpdateMaps(set map[string]map[string]interface{}, del map[string][]string) error {
+	return p.Tx(func(tx Tx) error {
+		for key, fields := range set {
+			tx.SetMap(key, fields)
+		}
+		for key, fields := range del {
+			tx.DelFromMap(key, fields...)
+		}
+		return nil
+	})
+}
+
+// ReplaceMap atomically replaces all fields of the map.
+func (p *Postgres) ReplaceMap(key string, m map[string]interface{}) error {
+	return p.Tx(func(tx Tx) error {
+		tx.ReplaceMap(key, m)
+		return nil
+	})
+}
+
+// Tx applies changes made by fn in one PostgreSQL transaction.
+func (p *Postgres) Tx(fn func(tx Tx) error) error {
+	ptx := &postgresTx{}
+	if err := fn(ptx); err != nil {
+		return err
+	}
+	if len(ptx.ops) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	return p.tx(ctx, func(tx *sql.Tx) error {
+		for _, op := range ptx.ops {
+			if err := op(ctx, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// postgresTx collects statements of the transaction, they are executed between BEGIN and COMMIT.
+type postgresTx struct {
+	ops []func(ctx context.Context, tx *sql.Tx) error
+}
+
+func (ptx *postgresTx) exec(query string, args ...interface{}) {
+	ptx.ops = append(ptx.ops, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+func (ptx *postgresTx) AddToList(key, item string) {
+	ptx.exec(`INSERT INTO distributor_lists (key, item) VALUES ($1, $2) ON CONFLICT (key, item) DO NOTHING`, key, item)
+}
+
+func (ptx *postgresTx) DelFromList(key, item string) {
+	ptx.exec(`DELETE FROM distributor_lists WHERE key = $1 AND item = $2`, key, item)
+}
+
+func (ptx *postgresTx) SetMap(key string, m map[string]interface{}) {
+	for field, value := range m {
+		ptx.exec(`INSERT INTO distributor_maps (key, field, value) VALUES ($1, $2, $3)
+			ON CONFLICT (key, field) DO UPDATE SET value = EXCLUDED.value`, key, field, toString(value))
+	}
+}
+
+func (ptx *postgresTx) DelFromMap(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	ptx.exec(`DELETE FROM distributor_maps WHERE key = $1 AND field = ANY($2)`, key, pq.Array(fields))
+}
+
+func (ptx *postgresTx) ReplaceMap(key string, m map[string]interface{}) {
+	ptx.exec(`DELETE FROM distributor_maps WHERE key = $1`, key)
+	ptx.SetMap(key, m)
+}
+
+// IncrBy increments the counter.
+func (p *Postgres) IncrBy(key string, n int64) (int64, error) {
+	var value int64
+	err := p.DB.QueryRow(`INSERT INTO distributor_counters (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = distributor_counters.value + EXCLUDED.value
+		RETURNING value`, key, n).Scan(&value)
+
+	return value, err
+}
+
+// Watch notifies about changes of the list or the map using LISTEN/NOTIFY, so changes made by other clients are seen too.
+// After reconnection to PostgreSQL all watchers are notified, since changes could be missed.
+func (p *Postgres) Watch(ctx context.Context, key string) (<-chan struct{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.listener == nil {
+		listener := pq.NewListener(p.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				logrus.Warnf("PostgreSQL listener: %s", err)
+			}
+		})
+		if err := listener.Listen(postgresChannel); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		p.listener = listener
+		go p.dispatch(listener)
+	}
+
+	return p.watchers.add(ctx, key), nil
+}
+
+// dispatch delivers notifications of the listener to watchers of the changed keys.
+func (p *Postgres) dispatch(listener *pq.Listener) {
+	for n := range listener.Notify {
+		// nil notification means reconnection
+		if n == nil {
+			p.watchers.notifyAll()
+			continue
+		}
+		p.watchers.notify(n.Extra)
+	}
+}
+
+// Ping checks the connection to PostgreSQL.
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.DB.PingContext(ctx)
+}
+
+// Close closes connections to PostgreSQL.
+func (p *Postgres) Close() error {
+	p.mu.Lock()
+	if p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+	p.mu.Unlock()
+
+	return p.DB.Close()
+}
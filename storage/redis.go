@@ -6,105 +6,113 @@ SPDX-License-Identifier: Apache-2.0
 package storage
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"io/ioutil"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
 )
 
+var _ Extended = (*Redis)(nil)
+
 // Redis struct implements Distributor Storage interface for RedisDB.
 type Redis struct {
 	Client redis.UniversalClient
 }
 
+// RedisMode is the topology of Redis deployment.
+type RedisMode string
+
+const (
+	// RedisAuto infers the mode from the options: sentinel if the master name is set,
+	// cluster if there are several addresses and single otherwise.
+	RedisAuto RedisMode = "auto"
+	// RedisSingle is a single Redis instance.
+	RedisSingle RedisMode = "single"
+	// RedisCluster is Redis Cluster, addresses are seed nodes.
+	RedisCluster RedisMode = "cluster"
+	// RedisSentinel is Redis with Sentinel failover, addresses are sentinels.
+	RedisSentinel RedisMode = "sentinel"
+)
+
+// ParseRedisMode returns the Redis mode by its name, empty name is RedisAuto.
+func ParseRedisMode(name string) (RedisMode, error) {
+	switch mode := RedisMode(name); mode {
+	case "":
+		return RedisAuto, nil
+	case RedisAuto, RedisSingle, RedisCluster, RedisSentinel:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown Redis mode %q", name)
+	}
+}
+
+// RedisOptions are options of the connection to Redis, zero values are go-redis defaults.
+type RedisOptions struct {
+	Mode             RedisMode
+	Addrs            []string
+	MasterName       string // Sentinel master name
+	SentinelPassword string // password of sentinels if it differs from the Redis one
+	Username         string // ACL user name, Redis 6.0 or greater
+	Password         string
+	DB               int // database index, must be 0 in cluster mode
+	PoolSize         int // max number of connections per node
+	DialTimeout      time.Duration
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	TLS              bool
+	RootCAs          []string // root CA certificates files, system ones if empty
+	ClientCert       string   // client certificate file for mTLS
+	ClientKey        string   // client private key file for mTLS
+}
+
 // NewRedis creates Redis storage implementation instance.
 func NewRedis(password string, addrs []string, withTLS bool, rootCAs []string) (*Redis, error) {
-	var (
-		redisOpts *redis.UniversalOptions
-		client    redis.UniversalClient
-	)
-
-	if withTLS {
-		certPool := x509.NewCertPool()
-
-		for _, rootCA := range rootCAs {
-			cert, err := ioutil.ReadFile(rootCA)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read root CA certificate %s", rootCA)
-			}
+	return NewRedisWithOptions(RedisOptions{
+		Addrs:    addrs,
+		Password: password,
+		TLS:      withTLS,
+		RootCAs:  rootCAs,
+	})
+}
 
-			if ok := certPool.AppendCertsFromPEM(cert); !ok {
-				return nil, fmt.Errorf(
-					"failed to add root CA certificate %s to the certificate pool",
-					rootCA,
-				)
-			}
+// NewRedisWithOptions creates Redis storage implementation instance for the Redis deployment of the mode.
+func NewRedisWithOptions(opts RedisOptions) (*Redis, error) {
+	redisOpts := &redis.UniversalOptions{
+		Addrs:           opts.Addrs,
+		MasterName:      opts.MasterName,
+		Username:        opts.Username,
+		Password:        opts.Password,
+		DB:              opts.DB,
+		PoolSize:        opts.PoolSize,
+		DialTimeout:     opts.DialTimeout,
+		ReadTimeout:     opts.ReadTimeout,
+		WriteTimeout:    opts.WriteTimeout,
+		ReadOnly:        false,
+		MaxRetries:      10000,
+		MaxRetryBackoff: 20 * time.Second,
+	}
+	if opts.TLS {
+		tlsConfig, err := clientTLSConfig(opts.RootCAs, opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, err
 		}
+		redisOpts.TLSConfig = tlsConfig
+	}
 
-		redisOpts = &redis.UniversalOptions{
-			Addrs:           addrs,
-			Password:        password,
-			ReadOnly:        false,
-			MaxRetries:      10000,
-			MaxRetryBackoff: 20 * time.Second,
-			TLSConfig:       &tls.Config{RootCAs: certPool},
-		}
-	} else {
-		redisOpts = &redis.UniversalOptions{
-			Addrs:           addrs,
-			Password:        password,
-			ReadOnly:        false,
-			MaxRetries:      10000,
-			MaxRetryBackoff: 20 * time.Second,
-		}
+	client, err := newRedisClient(opts, redisOpts)
+	if err != nil {
+		return nil, err
 	}
 
-	var (
-		err     error
-		success = make(chan struct{}, 1)
-	)
+	success := make(chan struct{}, 1)
 	go func() {
-		client = redis.NewUniversalClient(redisOpts)
 		err = client.Ping().Err()
 		success <- struct{}{}
 	}()
 
-	go func() {
-		t := time.NewTicker(20 * time.Second)
-		connHealthy := true
-		for range t.C {
-			if client == nil {
-				logrus.Warn("Redis client is nil")
-				continue
-			}
-
-			successPing := make(chan struct{})
-			go func() {
-				if err = client.Ping().Err(); err != nil {
-					return
-				}
-				close(successPing)
-			}()
-			select {
-			case <-time.After(2 * time.Second):
-				logrus.Warn("Redis ping timeout")
-				connHealthy = false
-			case <-successPing:
-				if !connHealthy {
-					logrus.Info("Redis connection restored")
-					connHealthy = true
-				}
-			}
-
-		}
-	}()
-
 	select {
 	case <-time.After(10 * time.Second):
 		err = errors.New("failed connect to the Redis")
@@ -114,6 +122,54 @@ func NewRedis(password string, addrs []string, withTLS bool, rootCAs []string) (
 	return &Redis{client}, err
 }
 
+// Ping checks the connection to Redis.
+func (r *Redis) Ping(ctx context.Context) error {
+	res := make(chan error, 1)
+	go func() {
+		res <- r.Client.Ping().Err()
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("Redis ping: %w", ctx.Err())
+	}
+}
+
+// newRedisClient creates the client for the Redis mode.
+func newRedisClient(opts RedisOptions, redisOpts *redis.UniversalOptions) (redis.UniversalClient, error) {
+	mode := opts.Mode
+	if mode == "" || mode == RedisAuto {
+		return redis.NewUniversalClient(redisOpts), nil
+	}
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("no Redis addresses")
+	}
+
+	switch mode {
+	case RedisSingle:
+		if len(opts.Addrs) > 1 {
+			return nil, fmt.Errorf("%d Redis addresses in single mode", len(opts.Addrs))
+		}
+		return redis.NewClient(redisOpts.Simple()), nil
+	case RedisCluster:
+		if opts.DB != 0 {
+			return nil, errors.New("Redis Cluster supports only database 0")
+		}
+		return redis.NewClusterClient(redisOpts.Cluster()), nil
+	case RedisSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("Redis Sentinel master name is required")
+		}
+		failoverOpts := redisOpts.Failover()
+		failoverOpts.SentinelPassword = opts.SentinelPassword
+		return redis.NewFailoverClient(failoverOpts), nil
+	default:
+		return nil, fmt.Errorf("unknown Redis mode %q", mode)
+	}
+}
+
 // Put saves value for for key.
 func (r *Redis) Put(key string, value []string) error {
 	data, err := Encode(value)
@@ -177,17 +233,192 @@ func (r *Redis) Close() error {
 	return r.Client.Close()
 }
 
-// GetMapField returns value for given map field.
+// GetMapField returns work units of the matching table value for given map field.
 func (r *Redis) GetMapField(key, field string) ([]string, error) {
-	resp := r.Client.HGet(key, field)
-	if resp.Err() != nil {
-		return nil, resp.Err()
+	res, err := r.GetField(key, field)
+	if err != nil {
+		return nil, err
 	}
 
-	res, err := resp.Result()
+	value, err := DecodeTableValue(res)
 	if err != nil {
 		return nil, err
 	}
 
-	return strings.Split(res, ","), nil
+	return value.Units, nil
+}
+
+// GetMap returns all fields and values of Redis Hash.
+func (r *Redis) GetMap(key string) (map[string]string, error) {
+	return r.Client.HGetAll(key).Result()
+}
+
+// UpdateMaps sets and deletes fields of Redis Hashes in a MULTI/EXEC transaction.
+// In Redis Cluster the update is atomic only for keys of the same hash slot.
+func (r *Redis) UpdateMaps(set map[string]map[string]interface{}, del map[string][]string) error {
+	_, err := r.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		for key, fields := range set {
+			if len(fields) != 0 {
+				pipe.HSet(key, fields)
+			}
+		}
+		for key, fields := range del {
+			if len(fields) != 0 {
+				pipe.HDel(key, fields...)
+			}
+		}
+		return nil
+	})
+
+	return err
+}
+
+// GetField returns the raw value of the Redis Hash field.
+func (r *Redis) GetField(key, field string) (string, error) {
+	res, err := r.Client.HGet(key, field).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+
+	return res, err
+}
+
+// IncrBy increments the Redis counter.
+func (r *Redis) IncrBy(key string, n int64) (int64, error) {
+	return r.Client.IncrBy(key, n).Result()
+}
+
+// addToListScript appends the item to the list if it's not there yet, so services can register repeatedly.
+var addToListScript = redis.NewScript(`
+for _, item in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if item == ARGV[1] then
+		return 0
+	end
+end
+return redis.call('RPUSH', KEYS[1], ARGV[1])
+`)
+
+// AddToList adds item to the end of Redis List if it's not there yet.
+func (r *Redis) AddToList(key, item string) error {
+	return addToListScript.Run(r.Client, []string{key}, item).Err()
+}
+
+// ReplaceMap replaces all fields of Redis Hash in a MULTI/EXEC transaction.
+func (r *Redis) ReplaceMap(key string, m map[string]interface{}) error {
+	return r.Tx(func(tx Tx) error {
+		tx.ReplaceMap(key, m)
+		return nil
+	})
+}
+
+// Tx applies changes made by fn in one pipelined MULTI/EXEC transaction.
+// In Redis Cluster all changed keys must be in the same hash slot.
+func (r *Redis) Tx(fn func(tx Tx) error) error {
+	tx := &redisTx{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	_, err := r.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, op := range tx.ops {
+			op(pipe)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// redisTx queues commands of the transaction.
+type redisTx struct {
+	ops []func(pipe redis.Pipeliner)
+}
+
+func (tx *redisTx) AddToList(key, item string) {
+	tx.ops = append(tx.ops, func(pipe redis.Pipeliner) {
+		// EVALSHA fails inside MULTI if the script isn't loaded, so the script is sent as is
+		addToListScript.Eval(pipe, []string{key}, item)
+	})
+}
+
+func (tx *redisTx) DelFromList(key, item string) {
+	tx.ops = append(tx.ops, func(pipe redis.Pipeliner) {
+		pipe.LRem(key, 0, item)
+	})
+}
+
+func (tx *redisTx) SetMap(key string, m map[string]interface{}) {
+	if len(m) == 0 {
+		return
+	}
+	tx.ops = append(tx.ops, func(pipe redis.Pipeliner) {
+		pipe.HSet(key, m)
+	})
+}
+
+func (tx *redisTx) DelFromMap(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	tx.ops = append(tx.ops, func(pipe redis.Pipeliner) {
+		pipe.HDel(key, fields...)
+	})
+}
+
+func (tx *redisTx) ReplaceMap(key string, m map[string]interface{}) {
+	tx.ops = append(tx.ops, func(pipe redis.Pipeliner) {
+		pipe.Del(key)
+	})
+	tx.SetMap(key, m)
+}
+
+// Watch notifies about changes of Redis List or Hash stored for key until ctx is done using keyspace notifications,
+// which must be enabled on the server, e.g. with "notify-keyspace-events Klhg". In Redis Cluster only changes
+// made on the node the client subscribed to are notified, so watchers should still poll from time to time.
+func (r *Redis) Watch(ctx context.Context, key string) (<-chan struct{}, error) {
+	pubsub := r.Client.PSubscribe("__keyspace@*__:" + escapePattern(key))
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	res := make(chan struct{}, 1)
+	go func() {
+		defer close(res)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case res <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+// escapePattern escapes glob special characters of Redis patterns.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
 }
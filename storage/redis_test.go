@@ -0,0 +1,146 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRedisClient(t *testing.T) {
+	_, err := ParseRedisMode("standalone")
+	assert.Error(t, err)
+	mode, err := ParseRedisMode("")
+	require.NoError(t, err)
+	assert.Equal(t, RedisAuto, mode)
+
+	for _, test := range []struct {
+		opts    RedisOptions
+		client  redis.UniversalClient
+		invalid bool
+	}{
+		{opts: RedisOptions{Addrs: []string{"redis:6379"}}, client: &redis.Client{}},
+		{opts: RedisOptions{Addrs: []string{"redis-1:6379", "redis-2:6379"}}, client: &redis.ClusterClient{}},
+		{opts: RedisOptions{Mode: RedisSingle, Addrs: []string{"redis:6379"}, DB: 2}, client: &redis.Client{}},
+		{opts: RedisOptions{Mode: RedisSingle, Addrs: []string{"redis-1:6379", "redis-2:6379"}}, invalid: true},
+		{opts: RedisOptions{Mode: RedisCluster, Addrs: []string{"redis:6379"}}, client: &redis.ClusterClient{}},
+		{opts: RedisOptions{Mode: RedisCluster, Addrs: []string{"redis:6379"}, DB: 1}, invalid: true},
+		{opts: RedisOptions{Mode: RedisSentinel, Addrs: []string{"sentinel:26379"}, MasterName: "master"}, client: &redis.Client{}},
+		{opts: RedisOptions{Mode: RedisSentinel, Addrs: []string{"sentinel:26379"}}, invalid: true},
+	} {
+		client, err := newRedisClient(test.opts, &redis.UniversalOptions{Addrs: test.opts.Addrs, MasterName: test.opts.MasterName})
+		if test.invalid {
+			assert.Error(t, err, test.opts)
+			continue
+		}
+		require.NoError(t, err, test.opts)
+		assert.IsType(t, test.client, client, test.opts)
+		client.Close()
+	}
+}
+
+// keyspaceHook publishes keyspace notifications of changed keys, since miniredis doesn't send them.
+type keyspaceHook struct {
+	server *miniredis.Miniredis
+}
+
+func (h keyspaceHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h keyspaceHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	h.notify(cmd)
+	return nil
+}
+
+func (h keyspaceHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h keyspaceHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		h.notify(cmd)
+	}
+	return nil
+}
+
+func (h keyspaceHook) notify(cmd redis.Cmder) {
+	if cmd.Err() != nil {
+		return
+	}
+	args := cmd.Args()
+	switch name := strings.ToLower(cmd.Name()); name {
+	case "rpush", "lrem", "hset", "hdel", "del":
+		h.server.Publish(fmt.Sprintf("__keyspace@0__:%v", args[1]), name)
+	case "eval", "evalsha":
+		h.server.Publish(fmt.Sprintf("__keyspace@0__:%v", args[3]), "rpush")
+	}
+}
+
+func startRedis(t *testing.T) *Redis {
+	server := miniredis.RunT(t)
+	r, err := NewRedis("", []string{server.Addr()}, false, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	r.Client.(*redis.Client).AddHook(keyspaceHook{server: server})
+
+	return r
+}
+
+func TestRedis(t *testing.T) {
+	r := startRedis(t)
+	testStorage(t, r)
+
+	// the script adds items once
+	require.NoError(t, r.AddToList("services", "service1"))
+	require.NoError(t, r.AddToList("services", "service1"))
+	services, err := r.GetList("services")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service1"}, services)
+
+	// a failed transaction changes nothing
+	assert.Error(t, r.Tx(func(tx Tx) error {
+		tx.AddToList("services", "service2")
+		return errors.New("failed")
+	}))
+	services, err = r.GetList("services")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service1"}, services)
+}
+
+func TestRedisWatch(t *testing.T) {
+	r := startRedis(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := r.Watch(ctx, "table[1]")
+	require.NoError(t, err)
+
+	// glob characters of the key are matched literally
+	require.NoError(t, r.SetMap("table1", map[string]interface{}{"service1": "work1"}))
+	require.NoError(t, r.SetMap("table[1]", map[string]interface{}{"service1": "work1"}))
+	notified(t, changes)
+	select {
+	case <-changes:
+		t.Fatal("change of another key is notified")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the channel is closed when ctx is done
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, ok := <-changes
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
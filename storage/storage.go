@@ -5,6 +5,11 @@ SPDX-License-Identifier: Apache-2.0
 
 package storage
 
+import (
+	"context"
+	"errors"
+)
+
 // Storage describes minimal interface for Distributor storage implementation.
 // Distributor's storage provides services lists, work units list and matching table.
 type Storage interface {
@@ -15,3 +20,268 @@ type Storage interface {
 	GetMapField(key, field string) ([]string, error)
 	Close() error
 }
+
+// Optional capabilities of storages, use the functions below to detect them.
+type (
+	// ListAdder adds items to lists.
+	ListAdder interface {
+		// AddToList adds item to the end of the list stored for key if it's not there yet.
+		AddToList(key, item string) error
+	}
+
+	// MapGetter reads whole maps.
+	MapGetter interface {
+		// GetMap returns all fields and values of the map stored for key, empty map if it's absent.
+		GetMap(key string) (map[string]string, error)
+	}
+
+	// MapsUpdater changes several maps at once.
+	MapsUpdater interface {
+		// UpdateMaps atomically sets fields of maps (key -> fields) and deletes fields from maps (key -> fields).
+		UpdateMaps(set map[string]map[string]interface{}, del map[string][]string) error
+	}
+
+	// MapReplacer replaces maps.
+	MapReplacer interface {
+		// ReplaceMap atomically replaces all fields of the map stored for key, the map is deleted if m is empty.
+		ReplaceMap(key string, m map[string]interface{}) error
+	}
+
+	// FieldGetter reads single fields of maps.
+	FieldGetter interface {
+		// GetField returns the raw value of the field of the map stored for key, ErrNotFound if it's absent.
+		GetField(key, field string) (string, error)
+	}
+
+	// Counter keeps counters.
+	Counter interface {
+		// IncrBy atomically increments the counter stored for key by n and returns the new value.
+		IncrBy(key string, n int64) (int64, error)
+	}
+
+	// Watcher notifies about changes.
+	Watcher interface {
+		// Watch notifies about changes of the list or the map stored for key until ctx is done.
+		// Notifications are coalesced, so a slow reader gets one notification for several changes.
+		Watch(ctx context.Context, key string) (<-chan struct{}, error)
+	}
+
+	// Transactional applies several changes atomically.
+	Transactional interface {
+		// Tx collects changes made by fn and applies them atomically if fn succeeds.
+		Tx(fn func(tx Tx) error) error
+	}
+
+	// HealthChecker checks connectivity.
+	HealthChecker interface {
+		// Ping returns an error if the storage is unavailable.
+		Ping(ctx context.Context) error
+	}
+
+	// Extended is the storage with all optional capabilities.
+	Extended interface {
+		Storage
+		ListAdder
+		MapGetter
+		MapsUpdater
+		MapReplacer
+		FieldGetter
+		Counter
+		Watcher
+		Transactional
+		HealthChecker
+	}
+)
+
+// Tx collects changes of a transaction, they are applied in the order they were made.
+type Tx interface {
+	AddToList(key, item string)
+	DelFromList(key, item string)
+	SetMap(key string, m map[string]interface{})
+	DelFromMap(key string, fields ...string)
+	ReplaceMap(key string, m map[string]interface{})
+}
+
+var (
+	// ErrNotFound is returned if the requested data is absent in storage.
+	ErrNotFound = errors.New("not found")
+	// ErrNotSupported is returned if the storage doesn't have the requested capability.
+	ErrNotSupported = errors.New("not supported by storage")
+)
+
+// AddToList adds item to the list if the storage is a ListAdder, ErrNotSupported otherwise.
+func AddToList(s Storage, key, item string) error {
+	adder, ok := s.(ListAdder)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return adder.AddToList(key, item)
+}
+
+// GetMap returns all fields and values of the map if the storage is a MapGetter, ErrNotSupported otherwise.
+func GetMap(s Storage, key string) (map[string]string, error) {
+	getter, ok := s.(MapGetter)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	return getter.GetMap(key)
+}
+
+// UpdateMaps sets and deletes fields of several maps atomically if the storage is a MapsUpdater or Transactional,
+// otherwise the maps are changed one by one.
+func UpdateMaps(s Storage, set map[string]map[string]interface{}, del map[string][]string) error {
+	if updater, ok := s.(MapsUpdater); ok {
+		return updater.UpdateMaps(set, del)
+	}
+
+	return Update(s, func(tx Tx) error {
+		for key, fields := range set {
+			tx.SetMap(key, fields)
+		}
+		for key, fields := range del {
+			tx.DelFromMap(key, fields...)
+		}
+		return nil
+	})
+}
+
+// ReplaceMap replaces all fields of the map. If the storage isn't a MapReplacer,
+// absent fields are deleted and new ones are set with UpdateMaps.
+func ReplaceMap(s Storage, key string, m map[string]interface{}) error {
+	if replacer, ok := s.(MapReplacer); ok {
+		return replacer.ReplaceMap(key, m)
+	}
+
+	current, err := GetMap(s, key)
+	if err != nil {
+		return err
+	}
+	var removed []string
+	for field := range current {
+		if _, ok := m[field]; !ok {
+			removed = append(removed, field)
+		}
+	}
+
+	return UpdateMaps(s, map[string]map[string]interface{}{key: m}, map[string][]string{key: removed})
+}
+
+// GetField returns the raw value of the map field if the storage is a FieldGetter,
+// otherwise the field is looked up in the whole map. ErrNotFound is returned if the field is absent.
+func GetField(s Storage, key, field string) (string, error) {
+	if getter, ok := s.(FieldGetter); ok {
+		return getter.GetField(key, field)
+	}
+
+	m, err := GetMap(s, key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := m[field]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+// GetUnitOwner returns the owner of the work unit from the reverse index map stored for key,
+// ErrNotFound if the work unit has no owner.
+func GetUnitOwner(s Storage, key, unit string) (UnitOwner, error) {
+	data, err := GetField(s, key, unit)
+	if err != nil {
+		return UnitOwner{}, err
+	}
+
+	return DecodeUnitOwner(data)
+}
+
+// IncrBy increments the counter if the storage is a Counter, returns ErrNotSupported otherwise.
+func IncrBy(s Storage, key string, n int64) (int64, error) {
+	counter, ok := s.(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	return counter.IncrBy(key, n)
+}
+
+// Watch notifies about changes of the list or the map if the storage is a Watcher, returns ErrNotSupported otherwise.
+func Watch(ctx context.Context, s Storage, key string) (<-chan struct{}, error) {
+	watcher, ok := s.(Watcher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	return watcher.Watch(ctx, key)
+}
+
+// Ping checks connectivity of the storage if it's a HealthChecker, other storages are considered available.
+func Ping(ctx context.Context, s Storage) error {
+	checker, ok := s.(HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	return checker.Ping(ctx)
+}
+
+// Update applies changes made by fn in a transaction if the storage is Transactional, one by one otherwise.
+func Update(s Storage, fn func(tx Tx) error) error {
+	if transactional, ok := s.(Transactional); ok {
+		return transactional.Tx(fn)
+	}
+
+	tx := &sequentialTx{s: s}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, op := range tx.ops {
+		if err := op(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sequentialTx collects changes applied one by one.
+type sequentialTx struct {
+	s   Storage
+	ops []func() error
+}
+
+func (tx *sequentialTx) AddToList(key, item string) {
+	tx.ops = append(tx.ops, func() error { return AddToList(tx.s, key, item) })
+}
+
+func (tx *sequentialTx) DelFromList(key, item string) {
+	tx.ops = append(tx.ops, func() error { return tx.s.DelFromList(key, item) })
+}
+
+func (tx *sequentialTx) SetMap(key string, m map[string]interface{}) {
+	if len(m) == 0 {
+		return
+	}
+	tx.ops = append(tx.ops, func() error { return tx.s.SetMap(key, m) })
+}
+
+func (tx *sequentialTx) DelFromMap(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	tx.ops = append(tx.ops, func() error {
+		for _, field := range fields {
+			if err := tx.s.DelFromMap(key, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (tx *sequentialTx) ReplaceMap(key string, m map[string]interface{}) {
+	tx.ops = append(tx.ops, func() error { return ReplaceMap(tx.s, key, m) })
+}
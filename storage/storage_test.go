@@ -0,0 +1,138 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorage checks the behaviour every storage must share. Keys are prefixed with the time of the run,
+// so storages sharing a database between runs are checked as well as empty ones.
+func testStorage(t *testing.T, s Storage) {
+	prefix := "test-" + time.Now().Format(time.RFC3339Nano) + "-"
+	services, table, owners, token := prefix+"services", prefix+"table", prefix+"owners", prefix+"token"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listChanges, err := Watch(ctx, s, services)
+	if !errors.Is(err, ErrNotSupported) {
+		require.NoError(t, err)
+	}
+	mapChanges, err := Watch(ctx, s, table)
+	if !errors.Is(err, ErrNotSupported) {
+		require.NoError(t, err)
+	}
+
+	// absent keys are empty
+	list, err := s.GetList(services)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+	m, err := GetMap(s, table)
+	require.NoError(t, err)
+	assert.Empty(t, m)
+
+	// lists keep the order of items, items are added once
+	require.NoError(t, AddToList(s, services, "service2"))
+	require.NoError(t, AddToList(s, services, "service1"))
+	require.NoError(t, AddToList(s, services, "service2"))
+	list, err = s.GetList(services)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service2", "service1"}, list)
+	notified(t, listChanges)
+	require.NoError(t, s.DelFromList(services, "service2"))
+	list, err = s.GetList(services)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service1"}, list)
+
+	// maps
+	value, err := EncodeTableValue(TableValue{Units: []string{"work,1", "work2"}}, FormatJSON)
+	require.NoError(t, err)
+	require.NoError(t, s.SetMap(table, map[string]interface{}{"service1": value, "service2": "work3"}))
+	notified(t, mapChanges)
+	units, err := s.GetMapField(table, "service1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"work,1", "work2"}, units)
+	_, err = s.GetMapField(table, "service3")
+	assert.ErrorIs(t, err, ErrNotFound)
+	field, err := GetField(s, table, "service2")
+	require.NoError(t, err)
+	assert.Equal(t, "work3", field)
+	_, err = GetField(s, table, "service3")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// updates of several maps
+	require.NoError(t, UpdateMaps(s,
+		map[string]map[string]interface{}{table: {"service3": "work3"}, owners: {"work3": `{"service":"service3","epoch":1}`}},
+		map[string][]string{table: {"service2"}},
+	))
+	m, err = GetMap(s, table)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service1": value, "service3": "work3"}, m)
+	owner, err := GetUnitOwner(s, owners, "work3")
+	require.NoError(t, err)
+	assert.Equal(t, UnitOwner{Service: "service3", Epoch: 1}, owner)
+	_, err = GetUnitOwner(s, owners, "work1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, s.DelFromMap(table, "service1"))
+	m, err = GetMap(s, table)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service3": "work3"}, m)
+
+	// replaced maps and transactions
+	require.NoError(t, ReplaceMap(s, table, map[string]interface{}{"service2": "work1,work2"}))
+	m, err = GetMap(s, table)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service2": "work1,work2"}, m)
+	require.NoError(t, Update(s, func(tx Tx) error {
+		tx.AddToList(services, "service2")
+		tx.DelFromList(services, "service1")
+		tx.ReplaceMap(table, map[string]interface{}{"service2": "work1"})
+		tx.SetMap(owners, map[string]interface{}{"work1": `{"service":"service2","epoch":2}`})
+		tx.DelFromMap(owners, "work3")
+		return nil
+	}))
+	list, err = s.GetList(services)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service2"}, list)
+	m, err = GetMap(s, table)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"service2": "work1"}, m)
+	m, err = GetMap(s, owners)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"work1": `{"service":"service2","epoch":2}`}, m)
+
+	// counters
+	n, err := IncrBy(s, token, 3)
+	if errors.Is(err, ErrNotSupported) {
+		return
+	}
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	n, err = IncrBy(s, token, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = IncrBy(s, token, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+}
+
+// notified waits for a change notification if the storage watches changes.
+func notified(t *testing.T, changes <-chan struct{}) {
+	if changes == nil {
+		return
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change is not notified")
+	}
+}
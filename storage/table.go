@@ -0,0 +1,167 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TableFormat is the encoding of matching table values.
+type TableFormat string
+
+const (
+	// FormatJSON encodes matching table values as versioned JSON objects.
+	FormatJSON TableFormat = "json"
+	// FormatLegacy encodes matching table values as comma-joined work units for old consumers.
+	FormatLegacy TableFormat = "legacy"
+
+	// TableValueVersion is the version of JSON encoded matching table values.
+	TableValueVersion = 1
+
+	maxUnitIDLength = 256 // max length of work unit ID in bytes
+)
+
+// TableValue is the matching table value of a service. Role and lease expiry are informational, the state
+// of the service and its leases are stored in their own maps.
+type TableValue struct {
+	Version     int        `json:"v"`
+	Units       []string   `json:"units"`
+	Epoch       int64      `json:"epoch,omitempty"`        // incremented on every change of the service work units
+	Role        string     `json:"role,omitempty"`         // state of the service unless it's active, e.g. draining
+	LeaseExpiry *time.Time `json:"lease_expiry,omitempty"` // earliest expiry of the leases the service holds
+}
+
+// ParseTableFormat returns the table format by its name.
+func ParseTableFormat(name string) (TableFormat, error) {
+	switch format := TableFormat(name); format {
+	case FormatJSON, FormatLegacy:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown matching table format %q", name)
+	}
+}
+
+// EncodeTableValue serializes the matching table value in the format.
+// The legacy format keeps only work units.
+func EncodeTableValue(value TableValue, format TableFormat) (string, error) {
+	if format == FormatLegacy {
+		return strings.Join(value.Units, ","), nil
+	}
+
+	value.Version = TableValueVersion
+	if value.Units == nil {
+		value.Units = []string{}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// DecodeTableValue deserializes the matching table value written in any format.
+func DecodeTableValue(data string) (TableValue, error) {
+	if strings.HasPrefix(data, "{") {
+		var value TableValue
+		if err := json.Unmarshal([]byte(data), &value); err == nil && value.Version != 0 {
+			if value.Version > TableValueVersion {
+				return TableValue{}, fmt.Errorf("unsupported matching table value version %d", value.Version)
+			}
+			return value, nil
+		}
+	}
+
+	// legacy comma-joined work units
+	if data == "" {
+		return TableValue{Units: []string{}}, nil
+	}
+
+	return TableValue{Units: strings.Split(data, ",")}, nil
+}
+
+// ValidateUnitID checks that the work unit ID can be stored in the matching table of the format.
+func ValidateUnitID(id string, format TableFormat) error {
+	if id == "" {
+		return errors.New("empty work unit ID")
+	}
+	if len(id) > maxUnitIDLength {
+		return fmt.Errorf("work unit ID is longer than %d bytes", maxUnitIDLength)
+	}
+	if !utf8.ValidString(id) {
+		return errors.New("work unit ID is not valid UTF-8")
+	}
+	for _, r := range id {
+		if unicode.IsControl(r) {
+			return errors.New("work unit ID contains control characters")
+		}
+	}
+	if format == FormatLegacy && strings.Contains(id, ",") {
+		return errors.New("work unit ID contains comma which is not allowed in the legacy matching table format")
+	}
+
+	return nil
+}
+
+// UnitOwner is the reverse index value of a work unit: the service owning it and the epoch of the service work units.
+type UnitOwner struct {
+	Service string `json:"service"`
+	Epoch   int64  `json:"epoch"`
+}
+
+// EncodeUnitOwner serializes the reverse index value.
+func EncodeUnitOwner(owner UnitOwner) (string, error) {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// DecodeUnitOwner deserializes the reverse index value.
+func DecodeUnitOwner(data string) (UnitOwner, error) {
+	var owner UnitOwner
+	if err := json.Unmarshal([]byte(data), &owner); err != nil {
+		return UnitOwner{}, fmt.Errorf("invalid work unit owner: %w", err)
+	}
+
+	return owner, nil
+}
+
+// Lease is the leases hash value of a work unit: the service owning the work unit until expiry and the fencing token,
+// which is greater than tokens of all previous leases of the work unit.
+type Lease struct {
+	Service string    `json:"service"`
+	Token   int64     `json:"token"`
+	Expiry  time.Time `json:"expiry"`
+}
+
+// EncodeLease serializes the leases hash value.
+func EncodeLease(lease Lease) (string, error) {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// DecodeLease deserializes the leases hash value.
+func DecodeLease(data string) (Lease, error) {
+	var lease Lease
+	if err := json.Unmarshal([]byte(data), &lease); err != nil {
+		return Lease{}, fmt.Errorf("invalid work unit lease: %w", err)
+	}
+
+	return lease, nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableValue(t *testing.T) {
+	value := TableValue{Units: []string{"work,1", "work2"}, Epoch: 3}
+
+	data, err := EncodeTableValue(value, FormatJSON)
+	require.NoError(t, err)
+	decoded, err := DecodeTableValue(data)
+	require.NoError(t, err)
+	assert.Equal(t, value.Units, decoded.Units)
+	assert.Equal(t, value.Epoch, decoded.Epoch)
+
+	expiry := time.Date(2022, 1, 2, 3, 4, 5, 6, time.UTC)
+	value = TableValue{Units: []string{"work1"}, Epoch: 3, Role: "draining", LeaseExpiry: &expiry}
+	data, err = EncodeTableValue(value, FormatJSON)
+	require.NoError(t, err)
+	assert.Equal(t, `{"v":1,"units":["work1"],"epoch":3,"role":"draining","lease_expiry":"2022-01-02T03:04:05.000000006Z"}`, data)
+	decoded, err = DecodeTableValue(data)
+	require.NoError(t, err)
+	assert.Equal(t, value.Role, decoded.Role)
+	assert.True(t, expiry.Equal(*decoded.LeaseExpiry))
+
+	data, err = EncodeTableValue(TableValue{Units: []string{"work1", "work2"}, Epoch: 3}, FormatLegacy)
+	require.NoError(t, err)
+	assert.Equal(t, "work1,work2", data)
+	decoded, err = DecodeTableValue(data)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"work1", "work2"}, decoded.Units)
+
+	decoded, err = DecodeTableValue("")
+	require.NoError(t, err)
+	assert.Empty(t, decoded.Units)
+
+	_, err = DecodeTableValue(`{"v":100,"units":[]}`)
+	assert.Error(t, err, "value of unknown version decoded")
+}
+
+func TestValidateUnitID(t *testing.T) {
+	assert.NoError(t, ValidateUnitID("channel-1", FormatLegacy))
+	assert.NoError(t, ValidateUnitID("channel,1", FormatJSON))
+	assert.Error(t, ValidateUnitID("channel,1", FormatLegacy))
+	assert.Error(t, ValidateUnitID("", FormatJSON))
+	assert.Error(t, ValidateUnitID("channel\n1", FormatJSON))
+}
@@ -0,0 +1,64 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// rootCAPool reads root CA certificates from files to the certificate pool.
+func rootCAPool(rootCAs []string) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+
+	for _, rootCA := range rootCAs {
+		cert, err := ioutil.ReadFile(rootCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root CA certificate %s", rootCA)
+		}
+
+		if ok := certPool.AppendCertsFromPEM(cert); !ok {
+			return nil, fmt.Errorf(
+				"failed to add root CA certificate %s to the certificate pool",
+				rootCA,
+			)
+		}
+	}
+
+	return certPool, nil
+}
+
+// clientTLSConfig returns TLS configuration with root CA certificates (system ones if there are no files)
+// and the client certificate for mTLS if its files are set.
+func clientTLSConfig(rootCAs []string, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	var files []string
+	for _, rootCA := range rootCAs {
+		if rootCA != "" {
+			files = append(files, rootCA)
+		}
+	}
+	if len(files) != 0 {
+		certPool, err := rootCAPool(files)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = certPool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// watchers keeps channels of Watch calls of in-process storages and notifies them about changed keys.
+type watchers struct {
+	mu    sync.Mutex // mutex for chans
+	chans map[string][]chan struct{}
+}
+
+// add returns the channel notified about changes of key until ctx is done.
+func (w *watchers) add(ctx context.Context, key string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	w.mu.Lock()
+	if w.chans == nil {
+		w.chans = make(map[string][]chan struct{})
+	}
+	w.chans[key] = append(w.chans[key], ch)
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		chans := w.chans[key]
+		for i, c := range chans {
+			if c == ch {
+				w.chans[key] = append(chans[:i], chans[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// notify notifies watchers of the keys.
+func (w *watchers) notify(keys ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, key := range keys {
+		for _, ch := range w.chans[key] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// notifyAll notifies all watchers.
+func (w *watchers) notifyAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, chans := range w.chans {
+		for _, ch := range chans {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
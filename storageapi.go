@@ -0,0 +1,144 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/scientificideas/distributor/storage"
+)
+
+// storageAPI serves the storage of the Distributor over HTTP, so services can read their work units and register
+// without access to the storage itself, e.g. if the storage is the embedded database file.
+//
+//	GET    /storage/list?key=                 list items
+//	PUT    /storage/list?key=&item=           add the item to the list
+//	DELETE /storage/list?key=&item=           remove the item from the list
+//	GET    /storage/map?key=                  all map fields
+//	GET    /storage/map?key=&field=           the map field value, e.g. the matching table value of a service
+//
+// All requests must have "Authorization: Bearer <token>" header. Only services and work units lists
+// and matching tables of the distribution groups are served, other keys such as leases and overrides are not.
+type storageAPI struct {
+	stor  storage.Storage
+	token string
+	lists map[string]struct{} // keys of served lists
+	maps  map[string]struct{} // keys of served maps
+}
+
+// newStorageAPI returns the storage API serving lists and maps of the groups keys.
+// The token must not be empty, since the API changes services lists.
+func newStorageAPI(stor storage.Storage, token string, keys []groupKeys) (*storageAPI, error) {
+	if token == "" {
+		return nil, errors.New("storage API token is not set")
+	}
+
+	s := &storageAPI{stor: stor, token: token, lists: make(map[string]struct{}), maps: make(map[string]struct{})}
+	for _, k := range keys {
+		s.lists[k.Services] = struct{}{}
+		s.lists[k.WorkUnits] = struct{}{}
+		s.maps[k.Table] = struct{}{}
+	}
+
+	return s, nil
+}
+
+func (s *storageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, s.token) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid storage API token"))
+		return
+	}
+
+	query := r.URL.Query()
+	key := query.Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("key is required"))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/storage/list":
+		if _, ok := s.lists[key]; !ok {
+			writeError(w, http.StatusForbidden, fmt.Errorf("list %s is not served", key))
+			return
+		}
+		s.list(w, r, key, query.Get("item"))
+	case "/storage/map":
+		if _, ok := s.maps[key]; !ok {
+			writeError(w, http.StatusForbidden, fmt.Errorf("map %s is not served", key))
+			return
+		}
+		s.getMap(w, r, key, query.Get("field"))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown storage method %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *storageAPI) list(w http.ResponseWriter, r *http.Request, key, item string) {
+	if r.Method == http.MethodGet {
+		items, err := s.stor.GetList(key)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if items == nil {
+			items = []string{}
+		}
+		writeJSON(w, items)
+		return
+	}
+
+	if item == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("item is required"))
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		if err = storage.AddToList(s.stor, key, item); errors.Is(err, storage.ErrNotSupported) {
+			writeError(w, http.StatusNotImplemented, err)
+			return
+		}
+	case http.MethodDelete:
+		err = s.stor.DelFromList(key, item)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *storageAPI) getMap(w http.ResponseWriter, r *http.Request, key, field string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	m, err := storage.GetMap(s.stor, key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if field == "" {
+		writeJSON(w, m)
+		return
+	}
+
+	value, ok := m[field]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("field %s: %w", field, storage.ErrNotFound))
+		return
+	}
+	writeJSON(w, value)
+}
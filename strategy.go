@@ -0,0 +1,231 @@
+/*
+Copyright Scientific Ideas 2022. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"sort"
+
+	"github.com/serialx/hashring"
+	"github.com/sirupsen/logrus"
+)
+
+// Strategy decides how work units are distributed between services.
+type Strategy interface {
+	// Table returns the matching table for services and work units.
+	// current is the matching table currently in use (empty before the first distribution),
+	// loads is the processing load of work units (units without reported load are absent).
+	Table(current map[string][]string, services, units []string, loads map[string]float64) map[string][]string
+}
+
+// LoadBalancer is implemented by strategies which periodically move work units between services by their load.
+type LoadBalancer interface {
+	Strategy
+	// Rebalance moves work units from overloaded to underloaded services and returns the new table
+	// and the number of moved units.
+	Rebalance(table map[string][]string, loads map[string]float64) (map[string][]string, int)
+}
+
+// DeterministicStrategy distributes work units with consistent hashing, so the same services and work units
+// always get the same distribution regardless of the current table and load.
+type DeterministicStrategy struct{}
+
+// Table distributes work units between services with consistent hashing.
+func (DeterministicStrategy) Table(_ map[string][]string, services, units []string, _ map[string]float64) map[string][]string {
+	return deterministicTable(services, units)
+}
+
+// deterministicTable creates matching table where each service has its own range of ring hash members.
+func deterministicTable(services, ringMembers []string) map[string][]string {
+	ring := hashring.NewWithWeights(nil)
+	for _, member := range ringMembers {
+		ring = ring.AddWeightedNode(member, 50)
+	}
+
+	// find matching ring members for every item
+	var table = make(map[string][]string)
+	itemsCountPerMember := len(ringMembers) / len(services)
+	if itemsCountPerMember < 1 {
+		itemsCountPerMember = 1
+	}
+
+	sort.Strings(services) // sort services list for consistent results
+	for i, service := range services {
+		if i == len(services)-1 && len(ringMembers) > len(services) { // if it's the last member and we have more free buckets than itemsCountPerMember,
+			itemsCountPerMember = itemsCountPerMember + len(ringMembers)%len(services) // give to this member itemsCountPerMember + len(ringMembers) % len(services) buckets
+		}
+		matchingMembers, ok := ring.GetNodes(service, itemsCountPerMember)
+		if !ok {
+			logrus.Debugf("failed to find matching hash ring member for item %s, skipping", service)
+		}
+		// rm member from hash ring to avoid duplication: one ring member can't be associated with two services
+		for _, member := range matchingMembers {
+			ring = ring.RemoveNode(member)
+		}
+		table[service] = matchingMembers
+	}
+
+	return table
+}
+
+// LoadAwareStrategy keeps work units on their services while possible, gives new and orphaned work units
+// to the least loaded services and periodically moves work units from overloaded to underloaded services.
+// A service is overloaded if its load exceeds the average by more than Hysteresis fraction
+// and underloaded if its load is below the average by more than Hysteresis fraction.
+type LoadAwareStrategy struct {
+	Hysteresis float64 // allowed deviation of service load from the average load, e.g. 0.2 for 20%
+	MaxMoves   int     // max number of work units moved by one Rebalance call
+}
+
+// Table keeps current assignments of existing work units to existing services and gives other work units
+// to the least loaded services. The first distribution is deterministic.
+func (s LoadAwareStrategy) Table(current map[string][]string, services, units []string, loads map[string]float64) map[string][]string {
+	if len(current) == 0 {
+		return deterministicTable(services, units)
+	}
+
+	known := make(map[string]struct{}, len(units))
+	for _, unit := range units {
+		known[unit] = struct{}{}
+	}
+
+	table := make(map[string][]string, len(services))
+	assigned := make(map[string]struct{}, len(units))
+	for _, service := range services {
+		table[service] = []string{}
+		for _, unit := range current[service] {
+			if _, ok := known[unit]; !ok {
+				continue
+			}
+			if _, ok := assigned[unit]; ok {
+				continue
+			}
+			table[service] = append(table[service], unit)
+			assigned[unit] = struct{}{}
+		}
+	}
+
+	unitLoad := unitLoadFunc(loads)
+	serviceLoads := loadsOf(table, unitLoad)
+	orphans := make([]string, 0, len(units)-len(assigned))
+	for _, unit := range units {
+		if _, ok := assigned[unit]; !ok {
+			orphans = append(orphans, unit)
+		}
+	}
+	sort.Strings(orphans)
+
+	for _, unit := range orphans {
+		service := leastLoaded(serviceLoads)
+		table[service] = append(table[service], unit)
+		serviceLoads[service] += unitLoad(unit)
+	}
+
+	return table
+}
+
+// Rebalance moves at most MaxMoves work units from the most loaded to the least loaded services while
+// they are out of the hysteresis band. Each move decreases the difference between these services loads.
+func (s LoadAwareStrategy) Rebalance(table map[string][]string, loads map[string]float64) (map[string][]string, int) {
+	if len(table) < 2 {
+		return table, 0
+	}
+
+	res := make(map[string][]string, len(table))
+	for service, units := range table {
+		res[service] = append([]string{}, units...)
+	}
+
+	unitLoad := unitLoadFunc(loads)
+	moves := 0
+	for ; moves < s.MaxMoves; moves++ {
+		serviceLoads := loadsOf(res, unitLoad)
+		var total float64
+		for _, load := range serviceLoads {
+			total += load
+		}
+		avg := total / float64(len(serviceLoads))
+
+		src, dst := mostLoaded(serviceLoads), leastLoaded(serviceLoads)
+		if serviceLoads[src] <= avg*(1+s.Hysteresis) || serviceLoads[dst] >= avg*(1-s.Hysteresis) {
+			break
+		}
+
+		// move the heaviest unit which still decreases the difference between src and dst loads
+		diff := serviceLoads[src] - serviceLoads[dst]
+		idx := -1
+		for i, unit := range res[src] {
+			if l := unitLoad(unit); l < diff && (idx == -1 || l > unitLoad(res[src][idx])) {
+				idx = i
+			}
+		}
+		if idx == -1 {
+			break
+		}
+
+		unit := res[src][idx]
+		res[src] = append(res[src][:idx], res[src][idx+1:]...)
+		res[dst] = append(res[dst], unit)
+		logrus.Debugf("move work unit %s from overloaded service %s to service %s", unit, src, dst)
+	}
+
+	return res, moves
+}
+
+// unitLoadFunc returns the load of work unit, work units without reported load are considered
+// as loaded as an average reported unit (or 1 if there are no reports at all).
+func unitLoadFunc(loads map[string]float64) func(string) float64 {
+	defaultLoad := 1.0
+	if len(loads) != 0 {
+		var total float64
+		for _, load := range loads {
+			total += load
+		}
+		defaultLoad = total / float64(len(loads))
+	}
+
+	return func(unit string) float64 {
+		if load, ok := loads[unit]; ok {
+			return load
+		}
+		return defaultLoad
+	}
+}
+
+func loadsOf(table map[string][]string, unitLoad func(string) float64) map[string]float64 {
+	res := make(map[string]float64, len(table))
+	for service, units := range table {
+		res[service] = 0
+		for _, unit := range units {
+			res[service] += unitLoad(unit)
+		}
+	}
+
+	return res
+}
+
+// leastLoaded returns the service with the minimal load, ties are resolved by service name for consistent results.
+func leastLoaded(loads map[string]float64) string {
+	var res string
+	for service, load := range loads {
+		if res == "" || load < loads[res] || (load == loads[res] && service < res) {
+			res = service
+		}
+	}
+
+	return res
+}
+
+// mostLoaded returns the service with the maximal load, ties are resolved by service name for consistent results.
+func mostLoaded(loads map[string]float64) string {
+	var res string
+	for service, load := range loads {
+		if res == "" || load > loads[res] || (load == loads[res] && service < res) {
+			res = service
+		}
+	}
+
+	return res
+}